@@ -21,6 +21,40 @@ var (
 	Quiet bool
 	Debug bool
 	MAXIT = 100
+	// EIGTHR is the magnitude below which Hessian eigenvalues are
+	// considered zero when characterizing a stationary point
+	EIGTHR = 1e-6
+	// MAXPASS is the maximum number of refits in RunIter, which stops
+	// once the stationary point shifts by less than STATTOL and its
+	// energy by less than ETOL
+	MAXPASS = 10
+	STATTOL = 1e-8
+	ETOL    = 1e-12
+	// Frozen marks coordinates to hold at zero in the stationary point
+	// search. Coordinates beyond its length are free
+	Frozen []bool
+	// GRADWT is the weight of each gradient row relative to the energy
+	// rows in FitGrad
+	GRADWT = 1.0
+	// Constraints are applied to the coefficients of every fit except
+	// those of an Updater
+	Constraints []Constraint
+	// LOSS is the loss function minimized by Fit. Any Loss other than
+	// LEASTSQ is fit by FitRobust
+	LOSS = LEASTSQ
+	// DropTerms makes Fit drop the terms that the points cannot
+	// determine, as found by CheckDesign, by fixing their coefficients
+	// to zero. Fits with gradients or streamed points never drop terms
+	DropTerms bool
+	// DUPTOL is the largest difference in any coordinate between two
+	// points considered duplicates, and DUPS is how ReadInput, and
+	// ReadGradients and ReadProps with it, handles them. ReadData takes
+	// its policies explicitly instead
+	DUPTOL = 1e-8
+	DUPS   = KEEP
+	// REFERENCE is how ReadInput chooses the reference energy to
+	// subtract from the energies of an input
+	REFERENCE = AUTO
 )
 
 type FC struct {
@@ -40,29 +74,74 @@ func toFloat(strs ...string) []float64 {
 	return ret
 }
 
-// ReadInput reads an anpass input file and returns the displacements,
-// energies, and exponents as []float64s. exps could be integers, but
-// you want them as floats for use in math.Pow
-func ReadInput(filename string) (disps *mat.Dense, energies []float64,
-	exps [][]int, biases []float64, stationary bool) {
-	f, err := os.Open(filename)
-	if err != nil {
-		panic(err)
-	}
-	scanner := bufio.NewScanner(f)
+// Point is a single data point from an anpass input file. Low is the
+// rounding error of Energy, so that Energy+Low holds the energy in the
+// input to about twice the precision of a float64. Grad is the gradient
+// at the point if the input has GRADIENTS and nil otherwise, and Props
+// holds the value of each property in the input's Props, with the
+// rounding errors in PropsLow like Low
+type Point struct {
+	Disp     []float64
+	Energy   float64
+	Low      float64
+	Grad     []float64
+	Props    []float64
+	PropsLow []float64
+}
+
+// Input holds the contents of an anpass input file other than the data
+// points. Gradients reports whether each data point is followed by its
+// gradient, as marked by a GRADIENTS line before the data. Props names
+// the additional properties, like dipole components, given on the line
+// after a PROPERTIES line, whose values end each data point. Composite
+// is the recipe from a COMPOSITE block for assembling the energies from
+// the properties, or nil if there is none. Constraints are read from a
+// CONSTRAINTS block
+type Input struct {
+	Nvbl        int
+	Exps        [][]int
+	Biases      []float64
+	Stationary  bool
+	Gradients   bool
+	Props       []string
+	Composite   *Composite
+	Constraints []Constraint
+}
+
+// ParseInput reads an anpass input from r, passing each data point to
+// fn as it is read instead of storing it. fn may be nil to read only
+// the rest of the input
+func ParseInput(r io.Reader, fn func(Point)) *Input {
+	scanner := bufio.NewScanner(r)
 	var (
 		line      string
 		fields    []string
-		dispSlice []float64
 		expsSlice []int
-		ndisps    int
+		in        Input
 	)
 	var handler func(string)
 	dispHandler := func(line string) {
 		fields = strings.Fields(line)
-		ndisps = len(fields) - 1
-		dispSlice = append(dispSlice, toFloat(fields[:ndisps]...)...)
-		energies = append(energies, toFloat(fields[ndisps])...)
+		nprop := len(in.Props)
+		in.Nvbl = len(fields) - 1 - nprop
+		if in.Gradients {
+			in.Nvbl /= 2
+		}
+		if fn != nil {
+			p := Point{Disp: toFloat(fields[:in.Nvbl]...)}
+			p.Energy, p.Low = parseEnergy(fields[in.Nvbl])
+			if in.Gradients {
+				p.Grad = toFloat(fields[in.Nvbl+1 : 2*in.Nvbl+1]...)
+			}
+			if nprop > 0 {
+				p.Props = make([]float64, nprop)
+				p.PropsLow = make([]float64, nprop)
+				for i, f := range fields[len(fields)-nprop:] {
+					p.Props[i], p.PropsLow[i] = parseEnergy(f)
+				}
+			}
+			fn(p)
+		}
 	}
 	unkHandler := func(line string) {
 		fields = strings.Fields(line)
@@ -71,15 +150,41 @@ func ReadInput(filename string) (disps *mat.Dense, energies []float64,
 			expsSlice = append(expsSlice, v)
 		}
 	}
+	propHandler := func(line string) {
+		in.Props = strings.Fields(line)
+		handler = nil
+	}
+	compHandler := func(line string) {
+		if !in.Composite.parseLine(line) {
+			handler = nil
+		}
+	}
+	consHandler := func(line string) {
+		c, ok := parseConstraint(line)
+		if !ok {
+			handler = nil
+			return
+		}
+		in.Constraints = append(in.Constraints, c)
+	}
 	statHandler := func(line string) {
 		fields = strings.Fields(line)
-		biases = append(biases, toFloat(fields...)...)
+		in.Biases = append(in.Biases, toFloat(fields...)...)
 	}
+	// the line after TITLE is free text, so it must not be taken for a
+	// keyword
+	var title bool
 	for scanner.Scan() {
 		line = scanner.Text()
-		switch {
+		if title {
+			title = false
+			continue
+		}
+		switch trim := strings.TrimSpace(line); {
 		case len(line) > 0 && line[0] == '!':
 			continue
+		case trim == "TITLE":
+			title = true
 		case strings.Contains(line, "("):
 			handler = dispHandler
 		case strings.Contains(line, "UNKNOWNS"):
@@ -88,19 +193,181 @@ func ReadInput(filename string) (disps *mat.Dense, energies []float64,
 			handler = unkHandler
 		case strings.Contains(line, "END OF DATA"):
 			handler = nil
+		case trim == "GRADIENTS":
+			in.Gradients = true
+			handler = nil
+		case trim == "PROPERTIES":
+			handler = propHandler
+		case trim == "CONSTRAINTS":
+			handler = consHandler
+		case trim == "COMPOSITE":
+			in.Composite = new(Composite)
+			handler = compHandler
 		case strings.Contains(line, "STATIONARY POINT"):
-			stationary = true
+			in.Stationary = true
 			handler = statHandler
 		case handler != nil:
 			handler(line)
 		}
 	}
-	if biases == nil {
-		biases = make([]float64, ndisps+1)
+	if in.Biases == nil {
+		in.Biases = make([]float64, in.Nvbl+1)
 	}
-	disps = mat.NewDense(len(dispSlice)/ndisps, ndisps, dispSlice)
-	exps = Reshape(ndisps, len(expsSlice)/ndisps, expsSlice)
-	return
+	in.Exps = Reshape(in.Nvbl, len(expsSlice)/in.Nvbl, expsSlice)
+	return &in
+}
+
+// parseFile calls ParseInput on the file filename
+func parseFile(filename string, fn func(Point)) *Input {
+	f, err := os.Open(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	return ParseInput(f, fn)
+}
+
+// ReadInput reads an anpass input file and returns the displacements,
+// energies, and exponents as []float64s. exps could be integers, but
+// you want them as floats for use in math.Pow. The energies are relative
+// to the reference energy chosen by REFERENCE. Duplicated points are
+// reported to stderr and handled according to DUPS
+func ReadInput(filename string) (disps *mat.Dense, energies []float64,
+	exps [][]int, biases []float64, stationary bool) {
+	d := ReadData(filename, REFERENCE, DUPS)
+	return d.Disps, d.Energies, d.Exps, d.Biases, d.Stationary
+}
+
+// Data is everything read from an anpass input file. Disps, Energies,
+// Grads and PropValues hold the data points after handling the
+// duplicates Dups, which are given by their indices in the file. The
+// energies are relative to the reference energy Ref if HasRef. Grads is
+// nil without GRADIENTS, and PropValues is nil without PROPERTIES, whose
+// names are in Props. With a COMPOSITE block, the reference is chosen
+// from the composite energies, and both the Energies and the PropValues
+// they are assembled from are relative to the same reference point
+type Data struct {
+	*Input
+	Disps      *mat.Dense
+	Energies   []float64
+	Grads      *mat.Dense
+	PropValues *mat.Dense
+	Ref        float64
+	HasRef     bool
+	Dups       []Duplicate
+}
+
+// ReadData reads the anpass input file filename in a single pass,
+// subtracting the reference energy chosen by ref from the energies and
+// handling duplicated points according to dups. Duplicated points are
+// also reported to stderr
+func ReadData(filename string, ref RefPolicy, dups DupPolicy) *Data {
+	var (
+		dispSlice []float64
+		hi, lo    []float64
+		grads     []float64
+		props     []float64
+		propsLow  []float64
+	)
+	in := parseFile(filename, func(p Point) {
+		dispSlice = append(dispSlice, p.Disp...)
+		hi = append(hi, p.Energy)
+		lo = append(lo, p.Low)
+		grads = append(grads, p.Grad...)
+		props = append(props, p.Props...)
+		propsLow = append(propsLow, p.PropsLow...)
+	})
+	d := &Data{Input: in}
+	disps := mat.NewDense(len(dispSlice)/in.Nvbl, in.Nvbl, dispSlice)
+	var energies []float64
+	if in.Composite != nil && len(in.Props) > 0 {
+		energies = d.composite(disps, props, propsLow, ref)
+	} else {
+		energies, d.Ref, d.HasRef = Reference(disps, hi, lo, ref)
+	}
+	d.Dups = FindDuplicates(disps, energies)
+	if len(d.Dups) > 0 && !Quiet {
+		fmt.Fprintf(os.Stderr, "WARNING: %d duplicated point(s) in %s, "+
+			"noise estimate %.2E\n", len(d.Dups), filename,
+			NoiseEstimate(d.Dups))
+	}
+	d.Disps = Resolve(disps, d.Dups, dups)
+	e := mat.NewDense(len(energies), 1, energies)
+	d.Energies = Resolve(e, d.Dups, dups).RawMatrix().Data
+	if in.Gradients {
+		d.Grads = Resolve(mat.NewDense(len(grads)/in.Nvbl, in.Nvbl,
+			grads), d.Dups, dups)
+	}
+	if n := len(in.Props); n > 0 {
+		d.PropValues = Resolve(mat.NewDense(len(props)/n, n, props),
+			d.Dups, dups)
+	}
+	return d
+}
+
+// composite returns the composite energies of the properties props,
+// whose rounding errors are in low, relative to the reference point
+// chosen from them by ref. Every property is made relative to the same
+// point, in place, so that the composite energies remain their sum
+func (d *Data) composite(disps *mat.Dense, props, low []float64,
+	ref RefPolicy) []float64 {
+	n := len(d.Props)
+	values := mat.NewDense(len(props)/n, n, props)
+	energies := d.Composite.Energies(d.Props, values)
+	r := refPoint(disps, energies, make([]float64, len(energies)), ref)
+	if r < 0 {
+		return energies
+	}
+	d.Ref, d.HasRef = energies[r], true
+	rhi := append([]float64(nil), props[r*n:(r+1)*n]...)
+	rlo := append([]float64(nil), low[r*n:(r+1)*n]...)
+	for i := range props {
+		props[i] = subtract(props[i], low[i], rhi[i%n], rlo[i%n])
+	}
+	return d.Composite.Energies(d.Props, values)
+}
+
+// ReadReference returns the reference energy subtracted from the
+// energies of the anpass input file filename by ReadInput, and whether
+// there is one
+func ReadReference(filename string) (ref float64, ok bool) {
+	d := ReadData(filename, REFERENCE, KEEP)
+	return d.Ref, d.HasRef
+}
+
+// ReadDuplicates returns the duplicated points in the anpass input file
+// filename, before any are removed by DUPS
+func ReadDuplicates(filename string) []Duplicate {
+	return ReadData(filename, REFERENCE, KEEP).Dups
+}
+
+// ReadGradients reads the gradient at each point of the anpass input
+// file filename, returning nil if the input has no GRADIENTS
+func ReadGradients(filename string) *mat.Dense {
+	return ReadData(filename, REFERENCE, DUPS).Grads
+}
+
+// ReadProps reads the names of the properties in the anpass input file
+// filename and their values at each point, returning nil for both if
+// the input has no PROPERTIES
+func ReadProps(filename string) (names []string, props *mat.Dense) {
+	d := ReadData(filename, REFERENCE, DUPS)
+	if d.PropValues == nil {
+		return nil, nil
+	}
+	return d.Props, d.PropValues
+}
+
+// ReadComposite reads the COMPOSITE block of the anpass input file
+// filename, returning nil if there is none
+func ReadComposite(filename string) *Composite {
+	return parseFile(filename, nil).Composite
+}
+
+// ReadConstraints reads the CONSTRAINTS block of the anpass input file
+// filename
+func ReadConstraints(filename string) []Constraint {
+	return parseFile(filename, nil).Constraints
 }
 
 // Dims returns the number of rows and cols in m, assuming each row in m has the
@@ -133,25 +400,10 @@ func Bias(disps *mat.Dense, energies, biases []float64) (*mat.Dense, []float64)
 	return ret, enew
 }
 
+// Eval evaluates the polynomial with coefficients C and exponents exps
+// at Xi
 func Eval(Xi, C []float64, exps [][]int) float64 {
-	var (
-		prod, sum float64
-		Ejk       int
-	)
-	for k := range C {
-		prod = C[k]
-		if math.Abs(prod) < THR {
-			continue
-		}
-		for j := range Xi {
-			Ejk = exps[j][k]
-			if Ejk != 0 {
-				prod *= math.Pow(Xi[j], float64(Ejk))
-			}
-		}
-		sum += prod
-	}
-	return sum
+	return compile(C, exps).eval(Xi)
 }
 
 func PrintMat(out io.Writer, matr mat.Matrix) {
@@ -176,67 +428,90 @@ func PrintVec(out io.Writer, v []float64) {
 
 // Fit determines the coefficient vector using ordinary least squares
 // and returns the solution vector along with the matrix describing
-// the function
+// the function. The rows of the design matrix and their contributions
+// to the normal equations are computed in parallel. If LOSS is not
+// LEASTSQ, the fit is instead done by FitRobust
 func Fit(disps *mat.Dense, energies []float64, exps [][]int) (
 	soln, fn *mat.Dense) {
+	return fit(disps, energies, exps, nil)
+}
+
+// fit is Fit, reusing check from dropCheck if it is not nil
+func fit(disps *mat.Dense, energies []float64, exps [][]int,
+	check *DesignCheck) (soln, fn *mat.Dense) {
+	if LOSS != LEASTSQ {
+		soln, fn, _ = fitRobust(disps, energies, exps, check)
+		return
+	}
+	return fitMulti(disps, mat.NewDense(len(energies), 1, energies), exps,
+		check)
+}
+
+// FitMulti is like a least-squares Fit, but fits each column of Y,
+// sharing the inverse of X^T X between them. Column k of soln holds the
+// coefficients for column k of Y. With Constraints or terms dropped by
+// DropTerms, each column is instead solved separately from the reduced
+// normal equations
+func FitMulti(disps, Y *mat.Dense, exps [][]int) (soln, fn *mat.Dense) {
+	return fitMulti(disps, Y, exps, nil)
+}
+
+// fitMulti is FitMulti, reusing check from dropCheck if it is not nil
+func fitMulti(disps, Y *mat.Dense, exps [][]int, check *DesignCheck) (
+	soln, fn *mat.Dense) {
+	if check == nil {
+		check = dropCheck(disps, exps)
+	}
 	_, coeffs := Dims(exps)
-	pts := len(energies)
-	var (
-		xijs []float64
-		arr  [5]float64
-	)
-	tmp := make([]float64, pts*coeffs)
-	for i := 0; i < pts; i++ {
-		xijs = disps.RawRowView(i)
-		ik := i * coeffs
-		for k := 0; k < coeffs; k++ {
-			tmp[ik] = 1.0
-			for j, xij := range xijs {
-				t2 := xij * xij
-				arr = [5]float64{
-					1,
-					xij,
-					t2,
-					t2 * xij,
-					t2 * t2,
-				}
-				tmp[ik] *= arr[exps[j][k]]
-			}
-			ik++
+	pts, cols := Y.Dims()
+	X := mat.NewDense(pts, coeffs, nil)
+	// only X^T X is needed, since the solution is formed from X^T below
+	ne := buildNormal(X, disps, nil, exps)
+	if cons := fitConstraints(check); len(cons) > 0 {
+		soln = mat.NewDense(coeffs, cols, nil)
+		for k := 0; k < cols; k++ {
+			var xty mat.VecDense
+			xty.MulVec(X.T(), Y.ColView(k))
+			// every column shares X^T X, so only the first warns
+			soln.SetCol(k, solveConstrained(ne.XTX, &xty, cons,
+				Quiet || k > 0).RawMatrix().Data)
 		}
+		return soln, X
 	}
-	X := mat.NewDense(pts, coeffs, tmp)
-	y := mat.NewDense(pts, 1, energies)
-	var XTX mat.Dense
-	XTX.Mul(X.T(), X)
 	var inv mat.Dense
-	err := inv.Inverse(&XTX)
-	if err != nil {
-		if strings.Contains(err.Error(), "Inf") {
-			panic(err)
-		}
-		if !Quiet {
-			fmt.Fprintf(os.Stderr, "WARNING: %v\n", err)
-		}
+	if err := inv.Inverse(ne.XTX); err != nil {
+		singular(err, check, disps, exps)
 	}
+	// (inv X^T) y instead of inv (X^T y) to keep the rounding of the
+	// original anpass in nearly singular fits
 	var mul mat.Dense
 	mul.Mul(&inv, X.T())
-	var sol mat.Dense
-	sol.Mul(&mul, y)
-	return &sol, X
+	soln = mat.NewDense(coeffs, cols, nil)
+	for k := 0; k < cols; k++ {
+		var sol mat.Dense
+		sol.Mul(&mul, mat.NewDense(pts, 1, mat.Col(nil, k, Y)))
+		soln.SetCol(k, sol.RawMatrix().Data)
+	}
+	return soln, X
 }
 
 // PrintResiduals computes and prints the residual for each point and
 // returns the sum of squared residuals
 func PrintResiduals(w io.Writer, x, A *mat.Dense, energies []float64) (
 	sum float64) {
-	fmt.Fprintf(w, "%5s%20s%20s%20s\n",
-		"POINT", "COMPUTED", "OBSERVED", "RESIDUAL")
 	var prod mat.Dense
 	prod.Mul(A, x)
-	var comp, resi float64
-	for i, obsv := range energies {
-		comp = prod.At(i, 0)
+	return printResiduals(w, prod.RawMatrix().Data, energies)
+}
+
+// printResiduals prints the computed and observed value and residual
+// for each point and returns the sum of squared residuals
+func printResiduals(w io.Writer, computed, observed []float64) (sum float64) {
+	fmt.Fprintf(w, "%5s%20s%20s%20s\n",
+		"POINT", "COMPUTED", "OBSERVED", "RESIDUAL")
+	var resi float64
+	for i, obsv := range observed {
+		comp := computed[i]
 		resi = comp - obsv
 		fmt.Fprintf(w, "%5d%20.12f%20.12f%20.8E\n",
 			i+1, comp, obsv, resi)
@@ -271,7 +546,7 @@ func Make9903(w io.Writer, coeffs *mat.Dense, exps [][]int) (ret []FC) {
 				iccount += iexpo
 			}
 		}
-		ffcc := coeffs.At(i, 0) * float64(ifact) * 4.359813653e0
+		ffcc := coeffs.At(i, 0) * float64(ifact) * htoaj
 		for _, f := range ictmp {
 			fmt.Fprintf(w, "%5d", f)
 		}
@@ -290,122 +565,45 @@ func Write9903(filename string, coeffs *mat.Dense, exps [][]int) []FC {
 	return Make9903(f, coeffs, exps)
 }
 
+// Grad returns the gradient of the polynomial with coefficients in
+// column 0 of coeffs and exponents exps at x
 func Grad(x []float64, coeffs *mat.Dense, exps [][]int) (grd []float64) {
-	var sum float64
-	nvbl, nunk := Dims(exps)
-	grd = make([]float64, nvbl)
-	for i := 0; i < nvbl; i++ {
-		sum = 0.0
-		for j := 0; j < nunk; j++ {
-			fij := float64(exps[i][j])
-			coj := coeffs.At(j, 0) * fij
-			if math.Abs(coj) < THR {
-				continue
-			}
-			if exps[i][j] != 1 {
-				coj *= math.Pow(x[i], fij-1)
-			}
-			for k := 0; k < nvbl; k++ {
-				ekj := exps[k][j]
-				if k != i && ekj != 0 {
-					coj *= math.Pow(x[k], float64(ekj))
-				}
-			}
-			sum += coj
-		}
-		grd[i] = sum
-	}
-	return
+	return compile(mat.Col(nil, 0, coeffs), exps).grad(x)
 }
 
+// Hess returns the Hessian of the polynomial with coefficients in
+// column 0 of coeffs and exponents exps at x
 func Hess(x []float64, coeffs *mat.Dense, exps [][]int) *mat.SymDense {
-	nvbl, nunk := Dims(exps)
-	var (
-		il  int
-		sum float64
-	)
-	coeffSlice := coeffs.RawMatrix().Data
-	hess := make([]float64, nvbl*(nvbl-1))
-	var (
-		coj           float64
-		eij, elj, ekj int
-		fij, flj      float64
-	)
-	for i := 0; i < nvbl; i++ {
-		for l := 0; l <= i; l++ {
-			sum = 0.0
-			if i != l { // => off-diagonal
-				for j := 0; j < nunk; j++ {
-					coj = coeffSlice[j]
-					eij = exps[i][j]
-					elj = exps[l][j]
-					fij = float64(eij)
-					flj = float64(elj)
-					coj *= fij * flj
-					if math.Abs(coj) < THR {
-						continue
-					}
-					if eij != 1 {
-						coj *= math.Pow(x[i], fij-1)
-					}
-					if elj != 1 {
-						coj *= math.Pow(x[l], flj-1)
-					}
-					for k := 0; k < nvbl; k++ {
-						if k != i && k != l {
-							if ekj = exps[k][j]; ekj != 0 {
-								coj *= math.Pow(x[k], float64(ekj))
-							}
-						}
-					}
-					sum += coj
-				}
-			} else { // => diagonal
-				for j := 0; j < nunk; j++ {
-					coj = coeffSlice[j]
-					eij = exps[i][j]
-					fij = float64(eij)
-					coj *= fij * (fij - 1)
-					if math.Abs(coj) < THR {
-						continue
-					}
-					if exps[i][j] != 2 {
-						coj *= math.Pow(x[i], fij-2)
-					}
-					for k := 0; k < nvbl; k++ {
-						if k != i {
-							if ekj = exps[k][j]; ekj != 0 {
-								coj *= math.Pow(x[k], float64(ekj))
-							}
-						}
-					}
-					sum += coj
-				}
-			}
-			hess[il] = sum
-			il++
-		}
-	}
-	ret := mat.NewSymDense(nvbl, nil)
-	il = 0
-	for i := 0; i < nvbl; i++ {
-		for j := 0; j <= i; j++ {
-			ret.SetSym(i, j, hess[il])
-			il++
-		}
-	}
-	return ret
+	return compile(mat.Col(nil, 0, coeffs), exps).hess(x)
 }
 
 // Newton uses the Newton-Raphson method to find the roots of the
-// equation given by coeffs and exps
+// equation given by coeffs and exps, holding any coordinates in Frozen
+// at zero
 func Newton(coeffs *mat.Dense, exps [][]int) []float64 {
+	return NewtonFrozen(coeffs, exps, Frozen)
+}
+
+// NewtonFrozen is like Newton, but only searches the subspace of
+// coordinates not marked in frozen. The frozen entries of the result are
+// exactly zero
+func NewtonFrozen(coeffs *mat.Dense, exps [][]int, frozen []bool) []float64 {
 	nvbl, _ := Dims(exps)
+	free := make([]int, 0, nvbl)
+	for i := 0; i < nvbl; i++ {
+		if i >= len(frozen) || !frozen[i] {
+			free = append(free, i)
+		}
+	}
 	x := make([]float64, nvbl)
+	poly := compile(mat.Col(nil, 0, coeffs), exps)
 	// if exceed 100, give up, too many iterations
 	for iter := 0; iter < MAXIT; iter++ {
-		grad := Grad(x, coeffs, exps)
-		hess := Hess(x, coeffs, exps)
+		grad := poly.grad(x)
+		hess := poly.hess(x)
+		if len(free) < nvbl {
+			grad, hess = subspace(grad, hess, free)
+		}
 		var invHess mat.Dense
 		err := invHess.Inverse(hess)
 		if err != nil {
@@ -438,37 +636,68 @@ func Newton(coeffs *mat.Dense, exps [][]int) []float64 {
 			}
 			fmt.Print("\n")
 		}
-		for i := range x {
-			x[i] -= del.At(i, 0)
+		for j, i := range free {
+			x[i] -= del.At(j, 0)
 		}
 	}
 	panic("TOO MANY NEWTON-RAPHSON ITERATIONS")
 }
 
+// subspace returns the elements of grad and hess corresponding to the
+// coordinates in idx
+func subspace(grad []float64, hess *mat.SymDense, idx []int) (
+	[]float64, *mat.SymDense) {
+	n := len(idx)
+	g := make([]float64, n)
+	h := mat.NewSymDense(n, nil)
+	for a, i := range idx {
+		g[a] = grad[i]
+		for b, j := range idx[:a+1] {
+			h.SetSym(a, b, hess.At(i, j))
+		}
+	}
+	return g, h
+}
+
+// Freeze returns a frozen slice of length nvbl with the 1-based
+// coordinates in coords set
+func Freeze(nvbl int, coords ...int) []bool {
+	ret := make([]bool, nvbl)
+	for _, c := range coords {
+		if c < 1 || c > nvbl {
+			panic(fmt.Sprintf("coordinate %d out of range", c))
+		}
+		ret[c-1] = true
+	}
+	return ret
+}
+
+// totSym is the set of totally symmetric irrep labels, in lowercase
+var totSym = map[string]bool{
+	"a": true, "a'": true, "a1": true, "ag": true, "a1g": true,
+	"a1'": true, "sigma+": true, "sigmag+": true, "sg+": true,
+}
+
+// FreezeIrreps returns a frozen slice marking every coordinate whose
+// irrep label in irreps is not totally symmetric
+func FreezeIrreps(irreps []string) []bool {
+	ret := make([]bool, len(irreps))
+	for i, irr := range irreps {
+		ret[i] = !totSym[strings.ToLower(irr)]
+	}
+	return ret
+}
+
 // Characterize stationary point x by computing the Hessian and
-// determining its eigenvalues
+// determining its eigenvalues, treating those below EIGTHR as zero. The
+// Hessian index and number of zero eigenvalues are given by Classify
 func Characterize(x []float64, coeffs *mat.Dense, exps [][]int) (
 	evals []float64, evecs *mat.Dense, kind Stat) {
 	h := Hess(x, coeffs, exps)
 	var eig mat.EigenSym
 	eig.Factorize(h, true)
 	evals = eig.Values(nil)
-	var prod int
-	for _, v := range evals {
-		if v < 0 {
-			prod--
-		} else if v > 0 {
-			prod++
-		}
-	}
-	switch l := len(evals); prod {
-	case -l:
-		kind = MAX
-	case l:
-		kind = MIN
-	default:
-		kind = STAT
-	}
+	kind = Classify(evals, EIGTHR).Kind
 	var vecs mat.Dense
 	eig.VectorsTo(&vecs)
 	evecs = &vecs
@@ -503,20 +732,116 @@ func CopyAnpass(infile, outfile string, longLine []float64) {
 	}
 }
 
+// WriteInput writes an anpass input file with title to w, containing the
+// data points in disps and energies and the FUNCTION block exps, and no
+// other sections
+func WriteInput(w io.Writer, title string, disps *mat.Dense,
+	energies []float64, exps [][]int) {
+	r, c := disps.Dims()
+	fmt.Fprintf(w, "!INPUT\nTITLE\n %s\n", title)
+	fmt.Fprintf(w, "INDEPENDENT VARIABLES\n%4d\n", c)
+	fmt.Fprintf(w, "DATA POINTS\n%4d%5d\n(%dF12.8,f20.12)\n", r, -2, c)
+	WritePoints(w, disps, energies)
+	_, coeffs := Dims(exps)
+	fmt.Fprintf(w, "UNKNOWNS\n%4d\nFUNCTION\n", coeffs)
+	for _, row := range exps {
+		for k, e := range row {
+			fmt.Fprintf(w, "%5d", e)
+			if (k+1)%16 == 0 || k == len(row)-1 {
+				fmt.Fprint(w, "\n")
+			}
+		}
+	}
+	fmt.Fprint(w, "END OF DATA\n!FIT\n!STATIONARY POINT\n!END\n")
+}
+
 // Run runs anpass: it computes the coefficients that fit disps, energies, and
 // exps; it then calls Newton to locate the stationary point and evaluates the
-// function at the stationary point.
+// function at the stationary point. stationary reports whether the point
+// found is within STATTOL of the origin of disps in every coordinate.
 func Run(w io.Writer, dir string, disps *mat.Dense, energies []float64,
 	exps [][]int) (longLine []float64, fcs []FC, stationary bool) {
-	coeffs, fn := Fit(disps, energies, exps)
-	PrintResiduals(w, coeffs, fn, energies)
+	check := dropCheck(disps, exps)
+	coeffs := fitResiduals(w, disps, energies, exps, check)
+	PrintConstraints(w, coeffs, exps)
+	PrintDropped(w, check, exps)
 	fcs = Write9903(filepath.Join(dir, "fort.9903"), coeffs, exps)
+	longLine = locate(w, coeffs, exps)
+	return longLine, fcs, atOrigin(longLine)
+}
+
+// atOrigin reports whether the coordinates in longLine are all within
+// STATTOL of zero
+func atOrigin(longLine []float64) bool {
+	for _, v := range longLine[:len(longLine)-1] {
+		if math.Abs(v) >= STATTOL {
+			return false
+		}
+	}
+	return true
+}
+
+// RunIter is like Run, but instead of a single fit it repeatedly biases
+// disps and energies to the stationary point of the previous pass and
+// refits, until the stationary point moves less than STATTOL in every
+// coordinate and its energy changes by less than ETOL, or MAXPASS passes
+// have been run. The returned longLine is the accumulated stationary
+// point relative to the original disps and energies, and fort.9903 is
+// only written for the final pass
+func RunIter(w io.Writer, dir string, disps *mat.Dense, energies []float64,
+	exps [][]int) (longLine []float64, fcs []FC, converged bool) {
+	_, nvbl := disps.Dims()
+	longLine = make([]float64, nvbl+1)
+	var coeffs *mat.Dense
+	for pass := 1; pass <= MAXPASS && !converged; pass++ {
+		fmt.Fprintf(w, "\nPASS %5d\n", pass)
+		PrintBias(w, longLine)
+		d, e := Bias(disps, energies, longLine)
+		check := dropCheck(d, exps)
+		coeffs = fitResiduals(w, d, e, exps, check)
+		PrintConstraints(w, coeffs, exps)
+		PrintDropped(w, check, exps)
+		x := locate(w, coeffs, exps)
+		var shift float64
+		for i, v := range x {
+			longLine[i] += v
+			if i < nvbl {
+				shift = math.Max(shift, math.Abs(v))
+			}
+		}
+		de := math.Abs(x[nvbl])
+		converged = shift < STATTOL && de < ETOL
+		fmt.Fprintf(w, "PASS %5d MAX SHIFT = %12.4E ENERGY CHANGE = %12.4E\n",
+			pass, shift, de)
+		for _, v := range longLine {
+			fmt.Fprintf(w, "%20.12f", v)
+		}
+		fmt.Fprint(w, "\n")
+	}
+	if !converged && !Quiet {
+		fmt.Fprintf(os.Stderr, "WARNING: stationary point not converged "+
+			"after %d passes\n", MAXPASS)
+	}
+	fcs = Write9903(filepath.Join(dir, "fort.9903"), coeffs, exps)
+	return longLine, fcs, converged
+}
+
+// locate finds and characterizes the stationary point of the function
+// described by coeffs and exps, printing the results to w, and returns
+// the long line of the stationary point coordinates followed by its
+// energy
+func locate(w io.Writer, coeffs *mat.Dense, exps [][]int) (longLine []float64) {
 	x := Newton(coeffs, exps)
 	// characterize stationary point found by Newton
-	evals, evecs, kind := Characterize(x, coeffs, exps)
+	evals, evecs, _ := Characterize(x, coeffs, exps)
+	kind := Classify(evals, EIGTHR)
 	fmt.Fprintf(w, "\n%s\n", kind)
+	if kind.Zero > 0 {
+		fmt.Fprintf(w, "WARNING: %d EIGENVALUE(S) OF HESSIAN BELOW %.1E, "+
+			"NEAR-SINGULAR DIRECTION(S)\n", kind.Zero, EIGTHR)
+	}
 	// print long line and intder steps
-	e := Eval(x, coeffs.RawMatrix().Data, exps)
+	e := Eval(x, mat.Col(nil, 0, coeffs), exps)
 	fmt.Fprintf(w, "WHERE ENERGY IS %20.12f\n", e)
 	at := "AT"
 	for i := range x {
@@ -538,7 +863,7 @@ func Run(w io.Writer, dir string, disps *mat.Dense, energies []float64,
 		}
 		fmt.Fprint(w, "\n")
 	}
-	return longLine, fcs, stationary
+	return longLine
 }
 
 func PrintBias(w io.Writer, biases []float64) {
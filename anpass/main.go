@@ -2,12 +2,16 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/ntBre/anpass"
+	"gonum.org/v1/gonum/mat"
 )
 
 var (
@@ -17,12 +21,113 @@ var (
 		"print nothing, don't even make output file")
 	once = flag.Bool("once", false,
 		"only run one pass, don't refit to stationary point")
+	iter = flag.Int("iter", 0,
+		"refit up to iter times until the stationary point converges, "+
+			"instead of the usual two passes")
+	stream = flag.Bool("stream", false,
+		"fit by streaming the data points instead of storing them")
+	shift = flag.Bool("shift", false,
+		"compare the refit coefficients to an exact shift of the first fit")
+	freeze = flag.String("freeze", "",
+		"comma-separated list of 1-based coordinates to hold at zero "+
+			"in the stationary point search")
+	eigthr = flag.Float64("eigthr", anpass.EIGTHR,
+		"magnitude below which Hessian eigenvalues are considered zero")
+	gradwt = flag.Float64("gradwt", anpass.GRADWT,
+		"weight of the gradient rows relative to the energies in inputs "+
+			"with GRADIENTS")
+	drop = flag.Bool("drop", false,
+		"drop the terms the points cannot determine, "+
+			"writing zero for their force constants")
+	dups = flag.String("dups", "keep",
+		"handling of duplicated points: keep, merge, average, or reject")
+	duptol = flag.Float64("duptol", anpass.DUPTOL,
+		"largest difference in any coordinate between duplicated points")
+	ref = flag.String("ref", "auto",
+		"reference energy to subtract: auto, none, origin, or minimum; "+
+			"auto subtracts the origin or minimum energy only from "+
+			"absolute energies")
+	loss = flag.String("loss", "leastsq",
+		"loss function of the fit: leastsq, huber, or tukey")
+	irreps = flag.String("irreps", "",
+		"comma-separated irrep label of each coordinate; "+
+			"non-totally symmetric coordinates are held at zero")
 )
 
+// setFrozen sets anpass.Frozen from the -freeze and -irreps flags
+func setFrozen(nvbl int) {
+	if *irreps != "" {
+		labels := strings.Split(*irreps, ",")
+		if len(labels) != nvbl {
+			panic("wrong number of irreps")
+		}
+		anpass.Frozen = anpass.FreezeIrreps(labels)
+	}
+	if *freeze != "" {
+		var coords []int
+		for _, f := range strings.Split(*freeze, ",") {
+			c, err := strconv.Atoi(strings.TrimSpace(f))
+			if err != nil {
+				panic(err)
+			}
+			coords = append(coords, c)
+		}
+		frozen := anpass.Freeze(nvbl, coords...)
+		for i, f := range anpass.Frozen {
+			frozen[i] = frozen[i] || f
+		}
+		anpass.Frozen = frozen
+	}
+}
+
 func main() {
 	flag.Parse()
+	anpass.EIGTHR = *eigthr
+	if !(*gradwt > 0) {
+		panic("-gradwt must be positive")
+	}
+	anpass.GRADWT = *gradwt
+	anpass.DropTerms = *drop
+	l, ok := anpass.Losses[strings.ToUpper(*loss)]
+	if !ok {
+		panic("unknown loss " + *loss)
+	}
+	anpass.LOSS = l
+	policy, ok := anpass.DupPolicies[strings.ToUpper(*dups)]
+	if !ok {
+		panic("unknown duplicate policy " + *dups)
+	}
+	anpass.DUPS = policy
+	anpass.DUPTOL = *duptol
+	refPolicy, ok := anpass.RefPolicies[strings.ToUpper(*ref)]
+	if !ok {
+		panic("unknown reference " + *ref)
+	}
+	anpass.REFERENCE = refPolicy
 	args := flag.Args()
-	var infile, outfile, infile2, outfile2 string
+	if len(args) > 0 {
+		switch args[0] {
+		case "transform":
+			transform(args[1:])
+			return
+		case "suggest":
+			suggest(args[1:])
+			return
+		case "select":
+			selectPoints(args[1:])
+			return
+		case "grid":
+			grid(args[1:])
+			return
+		case "check":
+			check(args[1:])
+			return
+		case "merge":
+			merge(args[1:])
+			return
+		}
+	}
+	var infile, outfile string
 	switch len(args) {
 	case 1:
 		infile = args[0]
@@ -33,6 +138,246 @@ func main() {
 	default:
 		panic("not enough args")
 	}
+	run(infile, outfile)
+}
+
+// runStream runs anpass on infile with anpass.RunStream, writing both
+// passes to out
+func runStream(out io.Writer, infile string) {
+	f, err := os.Open(infile)
+	if err != nil {
+		panic(err)
+	}
+	in := anpass.ParseInput(f, nil)
+	f.Close()
+	anpass.Constraints = in.Constraints
+	if in.Gradients || in.Props != nil {
+		panic("-stream does not support GRADIENTS or PROPERTIES")
+	}
+	stationary := in.Stationary
+	dir := filepath.Dir(infile)
+	longLine, _, atStat, err := anpass.RunStream(out, dir, infile, nil)
+	if err != nil {
+		panic(err)
+	}
+	if !*once && !stationary && !atStat {
+		fmt.Fprint(out, "\n")
+		_, _, _, err := anpass.RunStream(out, dir, infile, longLine)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// transform reads a transformation matrix and a fort.9903 file from
+// args and writes the force constants in the transformed coordinates to
+// the third argument, or to stdout if it is missing
+func transform(args []string) {
+	if len(args) < 2 || len(args) > 3 {
+		panic("usage: anpass transform matrix fort.9903 [outfile]")
+	}
+	L := anpass.ReadMatrix(args[0])
+	p, err := anpass.Read9903(args[1]).Transform(L)
+	if err != nil {
+		panic(err)
+	}
+	if len(args) == 3 {
+		p.Write9903(args[2])
+	} else {
+		anpass.Make9903(os.Stdout, mat.NewDense(len(p.Coeffs), 1, p.Coeffs),
+			p.Exps)
+	}
+}
+
+// suggest reads an anpass input file and writes the data lines of the
+// points anpass.Suggest proposes to add to it to stdout
+func suggest(args []string) {
+	fs := flag.NewFlagSet("suggest", flag.ExitOnError)
+	n := fs.Int("n", 10, "number of points to suggest")
+	fcs := fs.String("fc", "",
+		"comma-separated force constants to target, each given by its "+
+			"space-separated fort.9903 coordinates; "+
+			"all coefficients by default")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		panic("usage: anpass suggest [-n N] [-fc coords] infile")
+	}
+	disps, _, exps, _, _ := anpass.ReadInput(fs.Arg(0))
+	var targets []int
+	if *fcs != "" {
+		for _, fc := range strings.Split(*fcs, ",") {
+			var coord [4]int
+			fields := strings.Fields(fc)
+			if len(fields) > len(coord) {
+				panic("too many coordinates in " + fc)
+			}
+			for i, f := range fields {
+				c, err := strconv.Atoi(f)
+				if err != nil {
+					panic(err)
+				}
+				coord[i] = c
+			}
+			k := anpass.TermIndex(exps, coord)
+			if k < 0 {
+				panic("no term for force constant " + fc)
+			}
+			targets = append(targets, k)
+		}
+	}
+	points, _ := anpass.Suggest(disps, exps, *n, targets)
+	r, _ := points.Dims()
+	anpass.WritePoints(os.Stdout, points, make([]float64, r))
+}
+
+// selectPoints reads an anpass input file and writes the data lines of
+// the subset of its points chosen by anpass.Select to stdout, in their
+// original order, reporting the condition number of the reduced design
+// to stderr
+func selectPoints(args []string) {
+	fs := flag.NewFlagSet("select", flag.ExitOnError)
+	maxCond := fs.Float64("cond", 0,
+		"add points until the scaled design has at most this condition "+
+			"number; 0 keeps the smallest full-rank subset")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		panic("usage: anpass select [-cond c] infile")
+	}
+	disps, energies, exps, _, _ := anpass.ReadInput(fs.Arg(0))
+	idx, cond, err := anpass.Select(disps, exps, *maxCond)
+	if err != nil {
+		panic(err)
+	}
+	sort.Ints(idx)
+	_, nvbl := disps.Dims()
+	sub := mat.NewDense(len(idx), nvbl, nil)
+	nrg := make([]float64, len(idx))
+	for p, i := range idx {
+		sub.SetRow(p, disps.RawRowView(i))
+		nrg[p] = energies[i]
+	}
+	anpass.WritePoints(os.Stdout, sub, nrg)
+	fmt.Fprintf(os.Stderr, "%d OF %d POINTS, CONDITION NUMBER %.6E\n",
+		len(idx), len(energies), cond)
+}
+
+// grid writes an anpass input file for the displacement grid given by
+// the flags in args to stdout, with placeholder energies
+func grid(args []string) {
+	fs := flag.NewFlagSet("grid", flag.ExitOnError)
+	steps := fs.String("steps", "0.005",
+		"comma-separated step size of each coordinate, or a single step "+
+			"for every coordinate")
+	irreps := fs.String("irreps", "",
+		"comma-separated irrep label of each coordinate")
+	group := fs.String("group", "c1", "abelian point group of the irreps")
+	order := fs.Int("order", 4, "order of the force field")
+	title := fs.String("title", "", "title of the input file")
+	fs.Parse(args)
+	var labels []string
+	if *irreps != "" {
+		labels = strings.Split(*irreps, ",")
+	}
+	var stepv []float64
+	for _, f := range strings.Split(*steps, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil {
+			panic(err)
+		}
+		stepv = append(stepv, v)
+	}
+	if len(stepv) == 1 && len(labels) > 1 {
+		for len(stepv) < len(labels) {
+			stepv = append(stepv, stepv[0])
+		}
+	}
+	if labels == nil {
+		labels = make([]string, len(stepv))
+		for i := range labels {
+			labels[i] = "a"
+		}
+		*group = "c1"
+	}
+	if len(labels) != len(stepv) {
+		panic("number of steps does not match number of irreps")
+	}
+	g, ok := anpass.PointGroups[strings.ToLower(*group)]
+	if !ok {
+		panic("unknown point group " + *group)
+	}
+	disps := anpass.Grid(stepv, *order, labels, g)
+	r, _ := disps.Dims()
+	anpass.WriteInput(os.Stdout, *title, disps, make([]float64, r),
+		anpass.SymmetricExps(labels, g, *order))
+}
+
+// check reports whether the points in the input file in args can
+// determine every term of its FUNCTION block, exiting with status 1 if
+// they cannot
+func check(args []string) {
+	if len(args) != 1 {
+		panic("usage: anpass check infile")
+	}
+	disps, _, exps, _, _ := anpass.ReadInput(args[0])
+	d := anpass.CheckDesign(disps, exps)
+	d.Print(os.Stdout, exps)
+	if d.Rank < d.Terms {
+		os.Exit(1)
+	}
+}
+
+// merge writes an anpass input file combining the input files in args
+// to stdout, reporting the shift applied to the energies of each to
+// stderr
+func merge(args []string) {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	dups := fs.String("dups", "average",
+		"handling of duplicated points: keep, merge, average, or reject")
+	ref := fs.String("ref", "auto",
+		"reference energy to subtract from each input before merging: "+
+			"auto, none, origin, or minimum")
+	title := fs.String("title", "", "title of the merged input file")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		panic("usage: anpass merge [-dups policy] [-ref policy] " +
+			"[-title t] infile infile...")
+	}
+	policy, ok := anpass.DupPolicies[strings.ToUpper(*dups)]
+	if !ok {
+		panic("unknown duplicate policy " + *dups)
+	}
+	refPolicy, ok := anpass.RefPolicies[strings.ToUpper(*ref)]
+	if !ok {
+		panic("unknown reference " + *ref)
+	}
+	var sets []*anpass.Dataset
+	for _, infile := range fs.Args() {
+		set, err := anpass.ReadDataset(infile, refPolicy)
+		if err != nil {
+			panic(err)
+		}
+		sets = append(sets, set)
+	}
+	merged, shifts, err := anpass.Merge(sets, policy)
+	if err != nil {
+		panic(err)
+	}
+	if *title == "" {
+		*title = "MERGED FROM " + strings.Join(fs.Args(), " ")
+	}
+	anpass.WriteInput(os.Stdout, *title, merged.Disps, merged.Energies,
+		merged.Exps)
+	for i, infile := range fs.Args() {
+		fmt.Fprintf(os.Stderr, "SHIFTED ENERGIES OF %s BY %20.12f\n",
+			infile, shifts[i])
+	}
+}
+
+// run runs anpass on infile, writing the output to outfile. If infile
+// does not already contain a stationary point, and the first fit is not
+// at one, a second pass is run from anpass2.in in the current directory
+func run(infile, outfile string) {
+	var infile2, outfile2 string
 	var out io.Writer
 	if !*quiet {
 		f, err := os.Create(outfile)
@@ -44,14 +389,72 @@ func main() {
 	} else {
 		out = io.Discard
 	}
-	disps, energies, exps, biases, stationary := anpass.ReadInput(infile)
+	if *stream {
+		if anpass.LOSS != anpass.LEASTSQ || anpass.DUPS != anpass.KEEP {
+			panic("-stream does not support -loss or -dups")
+		}
+		if anpass.REFERENCE != anpass.AUTO &&
+			anpass.REFERENCE != anpass.NOREF {
+			panic("-stream supports only -ref auto or none")
+		}
+		runStream(out, infile)
+		return
+	}
+	data := anpass.ReadData(infile, anpass.REFERENCE, anpass.DUPS)
+	anpass.Constraints = data.Constraints
+	disps, energies, exps := data.Disps, data.Energies, data.Exps
+	biases, stationary := data.Biases, data.Stationary
+	grads, names, props := data.Grads, data.Props, data.PropValues
+	if grads != nil && props != nil {
+		panic("GRADIENTS and PROPERTIES cannot be fit together")
+	}
+	if (grads != nil || props != nil) && anpass.LOSS != anpass.LEASTSQ {
+		panic("-loss does not support GRADIENTS or PROPERTIES")
+	}
+	// ReadData has already assembled the energies of a COMPOSITE input
+	comp := data.Composite
+	if comp != nil && props == nil {
+		panic("COMPOSITE needs the components as PROPERTIES")
+	}
+	_, nvbl := disps.Dims()
+	setFrozen(nvbl)
 	anpass.PrintBias(out, biases)
+	if data.HasRef {
+		anpass.PrintReference(out, data.Ref)
+	}
+	anpass.PrintDuplicates(out, data.Dups)
 	disps, energies = anpass.Bias(disps, energies, biases)
 	dir := filepath.Dir(infile)
-	longLine, _, stationary := anpass.Run(out, dir, disps, energies, exps)
-	// pass the longline and do anpass2 if the first run wasn't on
-	// a stationary point
-	if !*once && !stationary {
+	// shifting the origin leaves the gradients and properties
+	// unchanged, so they are used as read for every pass
+	runOnce := func(disps *mat.Dense, energies []float64) (
+		[]float64, []anpass.FC, bool) {
+		switch {
+		case comp != nil:
+			// the components cannot share the energy bias, so the
+			// composite energies are left relative to the reference
+			return anpass.RunComposite(out, dir, disps, names, props,
+				comp, exps)
+		case grads != nil:
+			return anpass.RunGrad(out, dir, disps, energies, grads, exps)
+		case props != nil:
+			return anpass.RunProps(out, dir, disps, energies, props,
+				names, exps)
+		}
+		return anpass.Run(out, dir, disps, energies, exps)
+	}
+	if *iter > 0 && !stationary {
+		if grads != nil || props != nil {
+			panic("-iter does not support GRADIENTS or PROPERTIES")
+		}
+		anpass.MAXPASS = *iter
+		anpass.RunIter(out, dir, disps, energies, exps)
+		return
+	}
+	longLine, fcs, atStat := runOnce(disps, energies)
+	// pass the longline and do anpass2 if the input didn't already
+	// give the stationary point and the first run wasn't on one
+	if !*once && !stationary && !atStat {
 		infile2 = "anpass2.in"
 		anpass.CopyAnpass(infile, infile2, longLine)
 		outfile2 = strings.Replace(infile2, "in", "out", -1)
@@ -67,6 +470,14 @@ func main() {
 		}
 		anpass.PrintBias(out, longLine)
 		disps, energies = anpass.Bias(disps, energies, longLine)
-		anpass.Run(out, dir, disps, energies, exps)
+		_, fcs2, _ := runOnce(disps, energies)
+		if *shift {
+			fmt.Fprint(out, "\n")
+			anpass.PrintShift(out,
+				anpass.PolynomialFromFCs(nvbl, fcs),
+				anpass.PolynomialFromFCs(nvbl, fcs2),
+				longLine,
+			)
+		}
 	}
 }
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	*quiet = true
+	defer func() {
+		*quiet = false
+	}()
+	tests := []struct {
+		infile string
+		second bool
+	}{
+		{"../testfiles/anpass.in", true},
+		{"../testfiles/anpass2.in", false},
+		{"../testfiles/grad.in", true},
+	}
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	for _, test := range tests {
+		src, err := os.ReadFile(test.infile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir := t.TempDir()
+		os.Chdir(dir)
+		infile := filepath.Join(dir, "test.in")
+		os.WriteFile(infile, src, 0644)
+		run(infile, filepath.Join(dir, "test.out"))
+		_, err = os.Stat("anpass2.in")
+		if got := err == nil; got != test.second {
+			t.Errorf("%s: got second pass %v, wanted %v\n",
+				test.infile, got, test.second)
+		}
+		os.Chdir(wd)
+	}
+}
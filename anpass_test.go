@@ -7,6 +7,7 @@ import (
 	"math"
 	"os"
 	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 
@@ -204,6 +205,39 @@ func TestReadInput(t *testing.T) {
 	}
 }
 
+func TestParseInputTitle(t *testing.T) {
+	data, err := os.ReadFile("testfiles/anpass.in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := strings.Replace(string(data), "H2O 2A1 F12-TZ",
+		"H2O (2A1) WITH GRADIENTS, PROPERTIES AND CONSTRAINTS, "+
+			"NO COMPOSITE", 1)
+	var pts int
+	in := ParseInput(strings.NewReader(input), func(Point) { pts++ })
+	if in.Gradients || in.Props != nil || in.Composite != nil ||
+		in.Constraints != nil {
+		t.Errorf("keywords read from title: %+v\n", in)
+	}
+	if in.Nvbl != 3 || pts != 69 {
+		t.Errorf("got %d variables and %d points, wanted 3 and 69\n",
+			in.Nvbl, pts)
+	}
+}
+
+func TestReadData(t *testing.T) {
+	d := ReadData("testfiles/grad.in", REFERENCE, DUPS)
+	disps, energies, exps, biases, _ := ReadInput("testfiles/grad.in")
+	deepError(t, d.Disps, disps)
+	deepError(t, d.Energies, energies)
+	deepError(t, d.Exps, exps)
+	deepError(t, d.Biases, biases)
+	deepError(t, d.Grads, ReadGradients("testfiles/grad.in"))
+	if d.PropValues != nil || d.Dups != nil || d.HasRef {
+		t.Errorf("got %+v\n", d)
+	}
+}
+
 func TestBias(t *testing.T) {
 	disps := mat.NewDense(3, 4, []float64{
 		0.001, 0.002, 0.003, 0.004,
@@ -247,13 +281,72 @@ func TestReshape(t *testing.T) {
 	}
 }
 
+// BenchmarkFit compares fits on one goroutine to fits on every CPU, the
+// difference being the speedup from building the design matrix and
+// X^T X in parallel
 func BenchmarkFit(b *testing.B) {
 	Quiet = true
 	defer func() {
 		Quiet = false
 	}()
-	disps, energies, exps, _, _ := ReadInput("full_tests/c5h2.in")
-	for n := 0; n < b.N; n++ {
-		Fit(disps, energies, exps)
+	procs := []int{1}
+	if n := runtime.NumCPU(); n > 1 {
+		procs = append(procs, n)
+	}
+	for _, infile := range []string{"c3h2", "c4h3"} {
+		disps, energies, exps, _, _ := ReadInput("full_tests/" + infile +
+			".in")
+		for _, p := range procs {
+			b.Run(fmt.Sprintf("%s/procs=%d", infile, p),
+				func(b *testing.B) {
+					defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(p))
+					for n := 0; n < b.N; n++ {
+						Fit(disps, energies, exps)
+					}
+				})
+		}
 	}
 }
+
+func TestFreezeIrreps(t *testing.T) {
+	got := FreezeIrreps([]string{"A1", "a1", "B2", "A'", "A''", "Ag"})
+	want := []bool{false, false, true, false, true, false}
+	deepError(t, got, want)
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		evals []float64
+		want  Character
+		str   string
+	}{
+		{[]float64{1, 2, 3}, Character{MIN, 0, 0}, "M I N I M U M"},
+		{[]float64{-1e-9, 2, 3}, Character{MIN, 0, 1}, "M I N I M U M"},
+		{[]float64{-3, -2, -1}, Character{MAX, 3, 0}, "M A X I M U M"},
+		{[]float64{-3, 2, 3}, Character{SADDLE, 1, 0},
+			"SADDLE POINT OF ORDER 1"},
+		{[]float64{-3, -2, 3}, Character{SADDLE, 2, 0},
+			"SADDLE POINT OF ORDER 2"},
+		{[]float64{1e-8, -1e-8}, Character{STAT, 0, 2},
+			"S T A T I O N A R Y  P O I N T"},
+	}
+	for _, test := range tests {
+		got := Classify(test.evals, 1e-6)
+		deepError(t, got, test.want)
+		deepError(t, got.String(), test.str)
+	}
+}
+
+func TestFitDeterministic(t *testing.T) {
+	disps, energies, exps, _, _ := ReadInput("full_tests/c3h2.in")
+	Quiet = true
+	defer func() {
+		Quiet = false
+	}()
+	procs := runtime.GOMAXPROCS(1)
+	defer runtime.GOMAXPROCS(procs)
+	want, _ := Fit(disps, energies, exps)
+	runtime.GOMAXPROCS(4)
+	got, _ := Fit(disps, energies, exps)
+	deepError(t, got.RawMatrix().Data, want.RawMatrix().Data)
+}
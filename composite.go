@@ -0,0 +1,164 @@
+package anpass
+
+import (
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Extrapolation is a two-point complete basis set extrapolation formula
+type Extrapolation int
+
+const (
+	// HELGAKER extrapolates with the X^-3 form of Helgaker et al.
+	HELGAKER Extrapolation = iota
+	// MARTIN extrapolates with the (X+1/2)^-4 form of Martin
+	MARTIN
+)
+
+// Extrapolations are the Extrapolation formulas by their names in an
+// input file
+var Extrapolations = map[string]Extrapolation{
+	"HELGAKER": HELGAKER,
+	"MARTIN":   MARTIN,
+}
+
+// CBS extrapolates the correlation energies lo and hi, computed with
+// basis sets of cardinal numbers x < y, to the complete basis set limit
+func CBS(lo, hi float64, x, y int, formula Extrapolation) float64 {
+	var ratio float64
+	switch formula {
+	case HELGAKER:
+		ratio = math.Pow(float64(y)/float64(x), 3)
+	case MARTIN:
+		ratio = math.Pow((float64(y)+0.5)/(float64(x)+0.5), 4)
+	default:
+		panic("unknown extrapolation")
+	}
+	return hi + (hi-lo)/(ratio-1)
+}
+
+// Composite describes how the energy fit at each point is assembled
+// from the component energies given as properties in the input. It is
+// read from a COMPOSITE block with lines like
+//
+//	CBS TZ 3 QZ 4 HELGAKER
+//	ADD HF CORE REL
+//
+// where the CBS line extrapolates the components Lo and Hi, with
+// cardinal numbers X and Y, by Formula, defaulting to HELGAKER, and the
+// ADD line lists components added as they are
+type Composite struct {
+	Lo, Hi  string
+	X, Y    int
+	Formula Extrapolation
+	Add     []string
+}
+
+// parseLine updates c from a single line of a COMPOSITE block, reporting
+// whether the line belonged to the block
+func (c *Composite) parseLine(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "CBS":
+		if len(fields) < 5 {
+			panic("CBS needs two components and their cardinal numbers")
+		}
+		var err error
+		c.Lo, c.Hi = fields[1], fields[3]
+		if c.X, err = strconv.Atoi(fields[2]); err != nil {
+			panic(err)
+		}
+		if c.Y, err = strconv.Atoi(fields[4]); err != nil {
+			panic(err)
+		}
+		if len(fields) > 5 {
+			f, ok := Extrapolations[strings.ToUpper(fields[5])]
+			if !ok {
+				panic("unknown extrapolation " + fields[5])
+			}
+			c.Formula = f
+		}
+	case "ADD":
+		c.Add = append(c.Add, fields[1:]...)
+	default:
+		return false
+	}
+	return true
+}
+
+// Contributions returns the contribution of each part of c to the energy
+// at each point, given the component energies props with column names
+// names. The CBS extrapolation, if any, comes first, labeled CBS,
+// followed by each added component
+func (c *Composite) Contributions(names []string, props *mat.Dense) (
+	labels []string, contrib *mat.Dense) {
+	col := make(map[string]int)
+	for i, name := range names {
+		col[name] = i
+	}
+	index := func(name string) int {
+		i, ok := col[name]
+		if !ok {
+			panic("no component named " + name)
+		}
+		return i
+	}
+	pts, _ := props.Dims()
+	var data [][]float64
+	if c.Lo != "" {
+		lo, hi := index(c.Lo), index(c.Hi)
+		cbs := make([]float64, pts)
+		for i := range cbs {
+			cbs[i] = CBS(props.At(i, lo), props.At(i, hi), c.X, c.Y,
+				c.Formula)
+		}
+		labels = append(labels, "CBS")
+		data = append(data, cbs)
+	}
+	for _, name := range c.Add {
+		labels = append(labels, name)
+		data = append(data, mat.Col(nil, index(name), props))
+	}
+	contrib = mat.NewDense(pts, len(labels), nil)
+	for j, d := range data {
+		contrib.SetCol(j, d)
+	}
+	return
+}
+
+// Energies returns the composite energy at each point, the sum of the
+// Contributions
+func (c *Composite) Energies(names []string, props *mat.Dense) []float64 {
+	_, contrib := c.Contributions(names, props)
+	pts, n := contrib.Dims()
+	ret := make([]float64, pts)
+	for i := range ret {
+		for j := 0; j < n; j++ {
+			ret[i] += contrib.At(i, j)
+		}
+	}
+	return ret
+}
+
+// RunComposite is like Run for the composite energies from c.Energies,
+// but also fits each of the Contributions with the same factorization.
+// The energies are always the sum of the contributions, so unlike Run
+// the energies are not biased, and only disps may be shifted. The
+// residuals and coefficients of each contribution are printed after the
+// energy results, and their force constants, including the constant
+// term, sum to those in fort.9903 and are written to components.9903 in
+// dir in the same units
+func RunComposite(w io.Writer, dir string, disps *mat.Dense,
+	names []string, props *mat.Dense, c *Composite, exps [][]int) (
+	longLine []float64, fcs []FC, stationary bool) {
+	labels, contrib := c.Contributions(names, props)
+	return runParts(w, dir, disps, c.Energies(names, props), contrib,
+		labels, exps, "components.9903", htoaj)
+}
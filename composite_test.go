@@ -0,0 +1,147 @@
+package anpass
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCBS(t *testing.T) {
+	tests := []struct {
+		lo, hi  float64
+		x, y    int
+		formula Extrapolation
+		want    float64
+	}{
+		// E_inf = (27 lo - 64 hi) / (27 - 64) for TZ-QZ
+		{-0.3, -0.32, 3, 4, HELGAKER, (27*-0.3 - 64*-0.32) / (27 - 64)},
+		{-0.3, -0.32, 3, 4, MARTIN,
+			-0.32 + -0.02/(math.Pow(4.5/3.5, 4)-1)},
+	}
+	for _, test := range tests {
+		got := CBS(test.lo, test.hi, test.x, test.y, test.formula)
+		if !nearby(got, test.want, 1e-14) {
+			t.Errorf("got %v, wanted %v\n", got, test.want)
+		}
+	}
+}
+
+func TestRunComposite(t *testing.T) {
+	Quiet = true
+	defer func() {
+		Quiet = false
+	}()
+	dir := t.TempDir()
+	names := []string{"HF", "TZ", "QZ", "CORE"}
+	infile := writePropInput(t, dir, names,
+		func(x []float64, e float64) []float64 {
+			return []float64{0.6 * e, 0.3*e + 0.001, 0.35*e + 0.0005,
+				0.01 * e}
+		},
+		"COMPOSITE", " CBS TZ 3 QZ 4 HELGAKER", " ADD HF CORE")
+	c := ReadComposite(infile)
+	want := Composite{Lo: "TZ", Hi: "QZ", X: 3, Y: 4, Add: []string{"HF", "CORE"}}
+	if c == nil || c.Lo != want.Lo || c.Hi != want.Hi || c.X != want.X ||
+		c.Y != want.Y || strings.Join(c.Add, " ") != "HF CORE" {
+		t.Fatalf("got %+v, wanted %+v\n", c, want)
+	}
+	disps, _, exps, _, _ := ReadInput(infile)
+	gotNames, props := ReadProps(infile)
+	energies := c.Energies(gotNames, props)
+	for i := range energies {
+		tz, qz := props.At(i, 1), props.At(i, 2)
+		e := props.At(i, 0) + props.At(i, 3) +
+			(64*qz-27*tz)/(64-27)
+		if !nearby(energies[i], e, 1e-14) {
+			t.Fatalf("point %d: got %v, wanted %v\n", i, energies[i], e)
+		}
+	}
+	_, fcs, _ := RunComposite(io.Discard, dir, disps, gotNames, props, c,
+		exps)
+	_, wantFCs, _ := Run(io.Discard, dir, disps, energies, exps)
+	if !compFC(fcs, wantFCs, 0) {
+		t.Error("composite force constants differ from Run")
+	}
+	checkComponents(t, dir, fcs)
+}
+
+// checkComponents checks that the header of components.9903 in dir names
+// the contributions CBS, HF, and CORE, and that they sum to fcs
+func checkComponents(t *testing.T, dir string, fcs []FC) {
+	f, err := os.Open(filepath.Join(dir, "components.9903"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Scan()
+	if got := strings.Join(strings.Fields(scanner.Text())[5:], " "); got !=
+		"CBS HF CORE" {
+		t.Errorf("got header %q\n", got)
+	}
+	for i := 0; scanner.Scan(); i++ {
+		var sum float64
+		for _, v := range toFloat(strings.Fields(scanner.Text())[4:]...) {
+			sum += v
+		}
+		if !nearby(sum, fcs[i].Val, 1e-9*(1+math.Abs(fcs[i].Val))) {
+			t.Errorf("term %d: got %v, wanted %v\n", i, sum, fcs[i].Val)
+		}
+	}
+}
+
+func TestCompositeReference(t *testing.T) {
+	Quiet = true
+	defer func() {
+		Quiet = false
+	}()
+	names := []string{"HF", "TZ", "QZ", "CORE"}
+	infile := writePropInput(t, t.TempDir(), names,
+		func(x []float64, e float64) []float64 {
+			return []float64{-76.0 + 0.6*e, -0.3 + 0.3*e, -0.32 + 0.35*e,
+				-0.05 + 0.01*e}
+		},
+		"COMPOSITE", " CBS TZ 3 QZ 4 HELGAKER", " ADD HF CORE")
+	abs := ReadData(infile, NOREF, KEEP)
+	if abs.HasRef || abs.Energies[0] > -76 {
+		t.Fatalf("got %v with no reference\n", abs.Energies[0])
+	}
+	d := ReadData(infile, ORIGIN, KEEP)
+	r := refPoint(d.Disps, abs.Energies, make([]float64, len(abs.Energies)),
+		ORIGIN)
+	if !d.HasRef || d.Ref != abs.Energies[r] || d.Energies[r] != 0 {
+		t.Fatalf("got reference %v and energy %v at the origin\n", d.Ref,
+			d.Energies[r])
+	}
+	for i, e := range d.Energies {
+		if !nearby(e, abs.Energies[i]-d.Ref, 1e-11) {
+			t.Errorf("point %d: got %v, wanted %v\n", i, e,
+				abs.Energies[i]-d.Ref)
+		}
+	}
+	// the components are relative to the same point, so they still sum
+	// to the energies
+	if got := d.Composite.Energies(d.Props, d.PropValues); !eql(got,
+		d.Energies, 0) {
+		t.Error("components do not sum to the composite energies")
+	}
+	for j := range names {
+		if v := d.PropValues.At(r, j); v != 0 {
+			t.Errorf("%s: got %v at the origin\n", names[j], v)
+		}
+	}
+	// shifting the coordinates leaves the constant terms of the
+	// components summing to that of the composite energies
+	dir := t.TempDir()
+	disps, _ := Bias(d.Disps, d.Energies, []float64{0.001, -0.002, 0, 0.5})
+	_, fcs, _ := RunComposite(io.Discard, dir, disps, d.Props,
+		d.PropValues, d.Composite, d.Exps)
+	if fcs[0].Coord != [4]int{} || math.Abs(fcs[0].Val) > 1 {
+		t.Errorf("got constant term %v\n", fcs[0])
+	}
+	checkComponents(t, dir, fcs)
+}
@@ -0,0 +1,198 @@
+package anpass
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Constraint is an equality constraint on the coefficients of a fit. If
+// Tie is negative, the coefficient of term Term is fixed to Value.
+// Otherwise it is tied to equal the coefficient of term Tie. Terms are
+// 0-based columns of the exponent matrix
+type Constraint struct {
+	Term  int
+	Value float64
+	Tie   int
+}
+
+// Fix returns a Constraint fixing the coefficient of term to value
+func Fix(term int, value float64) Constraint {
+	return Constraint{Term: term, Value: value, Tie: -1}
+}
+
+// Tie returns a Constraint making the coefficient of term equal to that
+// of to
+func Tie(term, to int) Constraint {
+	return Constraint{Term: term, Tie: to}
+}
+
+// parseConstraint parses a line of a CONSTRAINTS block, either FIX k v
+// or TIE k l with 1-based term numbers, reporting whether the line
+// belonged to the block
+func parseConstraint(line string) (c Constraint, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return
+	}
+	term, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "FIX":
+		return Fix(term-1, toFloat(fields[2])[0]), true
+	case "TIE":
+		to, err := strconv.Atoi(fields[2])
+		if err != nil {
+			panic(err)
+		}
+		return Tie(term-1, to-1), true
+	}
+	return
+}
+
+// reduction describes the coefficients b of a fit subject to
+// constraints in terms of the free parameters z as b = b0 + N z, where
+// N[k][group[k]] = 1 and every other element of N is zero. Fixed terms
+// have a group of -1
+type reduction struct {
+	group []int
+	b0    []float64
+	free  int
+}
+
+// reduce returns the reduction of a fit with coeffs terms under cons
+func reduce(coeffs int, cons []Constraint) *reduction {
+	parent := make([]int, coeffs)
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for _, c := range cons {
+		if c.Tie >= 0 {
+			parent[find(c.Term)] = find(c.Tie)
+		}
+	}
+	fixed := make(map[int]float64)
+	for _, c := range cons {
+		if c.Tie >= 0 {
+			continue
+		}
+		root := find(c.Term)
+		if v, ok := fixed[root]; ok && v != c.Value {
+			panic(fmt.Sprintf("conflicting constraints on term %d",
+				c.Term+1))
+		}
+		fixed[root] = c.Value
+	}
+	r := &reduction{
+		group: make([]int, coeffs),
+		b0:    make([]float64, coeffs),
+	}
+	groups := make(map[int]int)
+	for k := range r.group {
+		root := find(k)
+		if v, ok := fixed[root]; ok {
+			r.group[k] = -1
+			r.b0[k] = v
+			continue
+		}
+		g, ok := groups[root]
+		if !ok {
+			g = r.free
+			groups[root] = g
+			r.free++
+		}
+		r.group[k] = g
+	}
+	return r
+}
+
+// solveConstrained solves the normal equations XTX b = XTy subject to
+// cons by solving the reduced equations for the free parameters, warning
+// about a singular system like Fit unless quiet
+func solveConstrained(XTX mat.Symmetric, XTy mat.Vector,
+	cons []Constraint, quiet bool) *mat.Dense {
+	coeffs := XTX.Symmetric()
+	r := reduce(coeffs, cons)
+	// right-hand side X^T (y - X b0)
+	var rhs mat.VecDense
+	rhs.MulVec(XTX, mat.NewVecDense(coeffs, r.b0))
+	rhs.SubVec(XTy, &rhs)
+	A := mat.NewSymDense(r.free, nil)
+	z := mat.NewVecDense(r.free, nil)
+	for k, g := range r.group {
+		if g < 0 {
+			continue
+		}
+		z.SetVec(g, z.AtVec(g)+rhs.AtVec(k))
+		for l, h := range r.group[k:] {
+			if h < 0 {
+				continue
+			}
+			v := XTX.At(k, k+l)
+			if l > 0 && g == h {
+				v *= 2
+			}
+			if g <= h {
+				A.SetSym(g, h, A.At(g, h)+v)
+			} else {
+				A.SetSym(h, g, A.At(h, g)+v)
+			}
+		}
+	}
+	var inv mat.Dense
+	if err := inv.Inverse(A); err != nil {
+		if strings.Contains(err.Error(), "Inf") {
+			panic(err)
+		}
+		if !quiet {
+			fmt.Fprintf(os.Stderr, "WARNING: %v\n", err)
+		}
+	}
+	var sol mat.VecDense
+	sol.MulVec(&inv, z)
+	ret := mat.NewDense(coeffs, 1, nil)
+	for k, g := range r.group {
+		v := r.b0[k]
+		if g >= 0 {
+			v = sol.AtVec(g)
+		}
+		ret.Set(k, 0, v)
+	}
+	return ret
+}
+
+// PrintConstraints prints the coefficient of each term in coeffs
+// affected by Constraints and how it was constrained, followed by the
+// number of free coefficients. It prints nothing if there are no
+// Constraints
+func PrintConstraints(w io.Writer, coeffs *mat.Dense, exps [][]int) {
+	if len(Constraints) == 0 {
+		return
+	}
+	_, terms := Dims(exps)
+	fmt.Fprintln(w, "CONSTRAINED COEFFICIENTS")
+	fmt.Fprintf(w, "%-20s%20s  %s\n", "TERM", "COEFFICIENT", "CONSTRAINT")
+	for _, c := range Constraints {
+		how := "FIXED"
+		if c.Tie >= 0 {
+			how = "TIED TO " + termString(column(exps, c.Tie))
+		}
+		fmt.Fprintf(w, "%-20s%20.12E  %s\n", termString(column(exps, c.Term)),
+			coeffs.At(c.Term, 0), how)
+	}
+	fmt.Fprintf(w, "FREE COEFFICIENTS: %d OF %d\n",
+		reduce(terms, Constraints).free, terms)
+}
@@ -0,0 +1,106 @@
+package anpass
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestConstraints(t *testing.T) {
+	Quiet = true
+	defer func() {
+		Quiet = false
+		Constraints = nil
+	}()
+	disps, energies, exps, _, _ := ReadInput("testfiles/anpass.in")
+	_, terms := Dims(exps)
+	soln, _ := Fit(disps, energies, exps)
+	want := mat.Col(nil, 0, soln)
+	tests := []struct {
+		name  string
+		cons  []Constraint
+		check func(got []float64) error
+	}{
+		{
+			name: "fix at unconstrained value",
+			cons: []Constraint{Fix(0, want[0])},
+			check: func(got []float64) error {
+				if got[0] != want[0] {
+					return fmt.Errorf("fixed term moved to %g", got[0])
+				}
+				if !eql(got, want, 1e-9) {
+					return fmt.Errorf("got %v, wanted %v", got, want)
+				}
+				return nil
+			},
+		},
+		{
+			name: "fix at zero",
+			cons: []Constraint{Fix(terms-1, 0)},
+			check: func(got []float64) error {
+				if got[terms-1] != 0 {
+					return fmt.Errorf("got %g, wanted 0", got[terms-1])
+				}
+				return nil
+			},
+		},
+		{
+			name: "tie",
+			cons: []Constraint{Tie(4, 5), Tie(6, 5)},
+			check: func(got []float64) error {
+				if got[4] != got[5] || got[6] != got[5] {
+					return fmt.Errorf("got %g, %g, %g, wanted equal",
+						got[4], got[5], got[6])
+				}
+				return nil
+			},
+		},
+		{
+			name: "tie to fixed",
+			cons: []Constraint{Tie(4, 5), Fix(5, 1e-3)},
+			check: func(got []float64) error {
+				if got[4] != 1e-3 || got[5] != 1e-3 {
+					return fmt.Errorf("got %g, %g, wanted 1e-3",
+						got[4], got[5])
+				}
+				return nil
+			},
+		},
+	}
+	for _, test := range tests {
+		Constraints = test.cons
+		soln, _ := Fit(disps, energies, exps)
+		if err := test.check(mat.Col(nil, 0, soln)); err != nil {
+			t.Errorf("%s: %v\n", test.name, err)
+		}
+	}
+}
+
+func TestReduceConflict(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic on conflicting constraints")
+		}
+	}()
+	reduce(3, []Constraint{Fix(0, 1), Tie(1, 0), Fix(1, 2)})
+}
+
+func TestReadConstraints(t *testing.T) {
+	data, err := os.ReadFile("testfiles/anpass.in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := strings.Replace(string(data), "DATA POINTS",
+		"CONSTRAINTS\nFIX 1 0.0\nTIE 5 6\nDATA POINTS", 1)
+	in := ParseInput(strings.NewReader(input), nil)
+	want := []Constraint{Fix(0, 0), Tie(4, 5)}
+	if fmt.Sprint(in.Constraints) != fmt.Sprint(want) {
+		t.Errorf("got %v, wanted %v\n", in.Constraints, want)
+	}
+	if in.Nvbl != 3 {
+		t.Errorf("got %d variables, wanted 3\n", in.Nvbl)
+	}
+}
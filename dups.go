@@ -0,0 +1,258 @@
+package anpass
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+)
+
+// DupPolicy is how ReadInput handles duplicated points
+type DupPolicy int
+
+const (
+	// KEEP leaves duplicated points in the fit
+	KEEP DupPolicy = iota
+	// MERGE keeps only the first of each set of duplicated points, with
+	// its own displacement and energy, and discards the rest
+	MERGE
+	// AVERAGE replaces each set of duplicated points by a single point
+	// at their mean displacement and energy
+	AVERAGE
+	// REJECT removes every point of each set of duplicated points
+	REJECT
+)
+
+// DupPolicies are the DupPolicy values by their names on the command
+// line
+var DupPolicies = map[string]DupPolicy{
+	"KEEP":    KEEP,
+	"MERGE":   MERGE,
+	"AVERAGE": AVERAGE,
+	"REJECT":  REJECT,
+}
+
+// Duplicate is a set of points whose displacements all agree within
+// DUPTOL with that of the first, given by their 0-based indices in increasing order, and their
+// energies
+type Duplicate struct {
+	Points   []int
+	Energies []float64
+}
+
+// Spread returns the difference between the highest and lowest energy
+// of the points in d
+func (d Duplicate) Spread() float64 {
+	return floats.Max(d.Energies) - floats.Min(d.Energies)
+}
+
+// FindDuplicates returns the sets of points in disps whose coordinates
+// all differ by at most DUPTOL from those of the first point of the set,
+// ordered by their first point. Each point belongs to the set of the
+// earliest point within DUPTOL of it, so points are never chained
+// together through a third point between them
+func FindDuplicates(disps *mat.Dense, energies []float64) []Duplicate {
+	pts, nvbl := disps.Dims()
+	// two points within DUPTOL lie in the same or neighboring cells of
+	// width DUPTOL in every coordinate, so sorting the points by their
+	// cells puts the candidates for each point in a few nested ranges
+	cell := make([]int64, pts*nvbl)
+	for i := 0; i < pts; i++ {
+		for k, x := range disps.RawRowView(i) {
+			cell[i*nvbl+k] = quantize(x)
+		}
+	}
+	order := make([]int, pts)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		ca := cell[order[a]*nvbl : order[a]*nvbl+nvbl]
+		cb := cell[order[b]*nvbl : order[b]*nvbl+nvbl]
+		for k := range ca {
+			if ca[k] != cb[k] {
+				return ca[k] < cb[k]
+			}
+		}
+		return order[a] < order[b]
+	})
+	// the cells in sorted order, to keep the searches below in cache
+	sorted := make([]int64, 0, len(cell))
+	for _, i := range order {
+		sorted = append(sorted, cell[i*nvbl:i*nvbl+nvbl]...)
+	}
+	same := func(i, j int) bool {
+		for k := 0; k < nvbl; k++ {
+			if math.Abs(disps.At(j, k)-disps.At(i, k)) > DUPTOL {
+				return false
+			}
+		}
+		return true
+	}
+	used := make([]bool, pts)
+	var (
+		d      Duplicate
+		search func(i, lo, hi, k int)
+	)
+	// search adds to d the unused points after i within DUPTOL of it
+	// among order[lo:hi], which share the cells of i before coordinate k
+	search = func(i, lo, hi, k int) {
+		if k == nvbl {
+			for _, j := range order[lo:hi] {
+				if j > i && !used[j] && same(i, j) {
+					used[j] = true
+					d.Points = append(d.Points, j)
+				}
+			}
+			return
+		}
+		c := cell[i*nvbl+k]
+		at := func(a int) int64 { return sorted[a*nvbl+k] }
+		// most ranges soon share a single cell, with no need to split
+		if first, last := at(lo), at(hi-1); first == last {
+			if first >= c-1 && first <= c+1 {
+				search(i, lo, hi, k+1)
+			}
+			return
+		}
+		l := lo + sort.Search(hi-lo, func(a int) bool {
+			return at(lo+a) >= c-1
+		})
+		for l < hi && at(l) <= c+1 {
+			// gallop to the end of the cells equal to at(l), which is
+			// usually near
+			v, h, step := at(l), l+1, 1
+			for h+step < hi && at(h+step) <= v {
+				h += step
+				step *= 2
+			}
+			end := h + step
+			if end > hi {
+				end = hi
+			}
+			h += sort.Search(end-h, func(a int) bool {
+				return at(h+a) > v
+			})
+			search(i, l, h, k+1)
+			l = h
+		}
+	}
+	var ret []Duplicate
+	for i := 0; i < pts; i++ {
+		if used[i] {
+			continue
+		}
+		d = Duplicate{Points: []int{i}}
+		search(i, 0, pts, 0)
+		if len(d.Points) == 1 {
+			continue
+		}
+		sort.Ints(d.Points)
+		for _, p := range d.Points {
+			d.Energies = append(d.Energies, energies[p])
+		}
+		ret = append(ret, d)
+	}
+	return ret
+}
+
+// quantize returns the index of the cell of width DUPTOL containing x,
+// or the bits of x if DUPTOL is not positive
+func quantize(x float64) int64 {
+	if DUPTOL <= 0 {
+		return int64(math.Float64bits(x + 0))
+	}
+	return int64(math.Floor(x / DUPTOL))
+}
+
+// Resolve applies policy to the rows of m, which belong to the points
+// with duplicates dups, in the order of the points, returning a new
+// matrix. With KEEP, m itself is returned
+func Resolve(m *mat.Dense, dups []Duplicate, policy DupPolicy) *mat.Dense {
+	if policy == KEEP || len(dups) == 0 {
+		return m
+	}
+	rows, cols := m.Dims()
+	// dup[i] is the set containing point i, or -1
+	dup := make([]int, rows)
+	for i := range dup {
+		dup[i] = -1
+	}
+	for g, d := range dups {
+		for _, i := range d.Points {
+			dup[i] = g
+		}
+	}
+	var data []float64
+	for i := 0; i < rows; i++ {
+		g := dup[i]
+		switch {
+		case g < 0:
+			data = append(data, m.RawRowView(i)...)
+		case policy == REJECT || dups[g].Points[0] != i:
+		case policy == MERGE:
+			data = append(data, m.RawRowView(i)...)
+		case policy == AVERAGE:
+			mean := make([]float64, cols)
+			for _, p := range dups[g].Points {
+				for k, v := range m.RawRowView(p) {
+					mean[k] += v
+				}
+			}
+			for k := range mean {
+				mean[k] /= float64(len(dups[g].Points))
+			}
+			data = append(data, mean...)
+		}
+	}
+	if len(data) == 0 {
+		panic("no points left after removing duplicates")
+	}
+	return mat.NewDense(len(data)/cols, cols, data)
+}
+
+// NoiseEstimate returns the pooled standard deviation of the energies of
+// each set of duplicated points in dups, an estimate of the noise in the
+// energies, or zero if there are no duplicates
+func NoiseEstimate(dups []Duplicate) float64 {
+	var ss float64
+	var dof int
+	for _, d := range dups {
+		var mean float64
+		for _, e := range d.Energies {
+			mean += e
+		}
+		mean /= float64(len(d.Energies))
+		for _, e := range d.Energies {
+			ss += (e - mean) * (e - mean)
+		}
+		dof += len(d.Energies) - 1
+	}
+	if dof == 0 {
+		return 0
+	}
+	return math.Sqrt(ss / float64(dof))
+}
+
+// PrintDuplicates prints the 1-based points of each set of duplicates in
+// dups with the spread of their energies, followed by the NoiseEstimate.
+// It prints nothing if there are no duplicates
+func PrintDuplicates(w io.Writer, dups []Duplicate) {
+	if len(dups) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "DUPLICATE POINTS")
+	fmt.Fprintf(w, "%-30s%20s\n", "POINTS", "ENERGY SPREAD")
+	for _, d := range dups {
+		var pts string
+		for _, p := range d.Points {
+			pts += fmt.Sprintf("%5d", p+1)
+		}
+		fmt.Fprintf(w, "%-30s%20.8E\n", pts, d.Spread())
+	}
+	fmt.Fprintf(w, "NOISE ESTIMATE FROM DUPLICATES IS %17.8E\n",
+		NoiseEstimate(dups))
+}
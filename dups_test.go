@@ -0,0 +1,191 @@
+package anpass
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	disps := mat.NewDense(6, 2, []float64{
+		0.005, 0,
+		0, 0.005,
+		0.005, 0,
+		0, 0.005 + 5e-9,
+		0, 0.005 - 2e-8,
+		0.005, 0,
+	})
+	energies := []float64{1, 2, 3, 4, 5, 7}
+	got := FindDuplicates(disps, energies)
+	want := []Duplicate{
+		{Points: []int{0, 2, 5}, Energies: []float64{1, 3, 7}},
+		{Points: []int{1, 3}, Energies: []float64{2, 4}},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %v, wanted %v\n", got, want)
+	}
+	if s := got[0].Spread(); s != 6 {
+		t.Errorf("got spread %g, wanted 6\n", s)
+	}
+	// pooled over (1, 3, 7) and (2, 4): (168/9 + 2) / 3
+	if n := NoiseEstimate(got); !nearby(n*n, 62.0/9, 1e-12) {
+		t.Errorf("got noise %g, wanted %g\n", n*n, 62.0/9)
+	}
+	tests := []struct {
+		policy DupPolicy
+		want   []float64
+	}{
+		{KEEP, energies},
+		{MERGE, []float64{1, 2, 5}},
+		{AVERAGE, []float64{11.0 / 3, 3, 5}},
+		{REJECT, []float64{5}},
+	}
+	for _, test := range tests {
+		e := mat.NewDense(len(energies), 1, energies)
+		got := Resolve(e, got, test.policy).RawMatrix().Data
+		if !eql(got, test.want, 1e-14) {
+			t.Errorf("policy %d: got %v, wanted %v\n", test.policy, got,
+				test.want)
+		}
+	}
+}
+
+func TestFindDuplicatesChained(t *testing.T) {
+	// each point is within DUPTOL of the next, but the first and last
+	// are not
+	disps := mat.NewDense(4, 2, []float64{
+		0.01, 0,
+		0.01 + 0.8e-8, 0,
+		0.01 + 1.6e-8, 0,
+		0.01 + 2.4e-8, 1e-9,
+	})
+	got := FindDuplicates(disps, []float64{1, 2, 3, 4})
+	want := []Duplicate{
+		{Points: []int{0, 1}, Energies: []float64{1, 2}},
+		{Points: []int{2, 3}, Energies: []float64{3, 4}},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, wanted %v\n", got, want)
+	}
+}
+
+// naiveDuplicates is FindDuplicates by comparing every pair of points
+func naiveDuplicates(disps *mat.Dense, energies []float64) []Duplicate {
+	pts, nvbl := disps.Dims()
+	used := make([]bool, pts)
+	var ret []Duplicate
+	for i := 0; i < pts; i++ {
+		if used[i] {
+			continue
+		}
+		d := Duplicate{Points: []int{i}, Energies: []float64{energies[i]}}
+		for j := i + 1; j < pts; j++ {
+			same := !used[j]
+			for k := 0; k < nvbl && same; k++ {
+				same = math.Abs(disps.At(i, k)-disps.At(j, k)) <= DUPTOL
+			}
+			if same {
+				used[j] = true
+				d.Points = append(d.Points, j)
+				d.Energies = append(d.Energies, energies[j])
+			}
+		}
+		if len(d.Points) > 1 {
+			ret = append(ret, d)
+		}
+	}
+	return ret
+}
+
+func TestFindDuplicatesGrid(t *testing.T) {
+	// a grid where every point shares its first coordinate with
+	// thousands of others, with some points repeated just inside and
+	// outside DUPTOL and across the edges of the cells
+	var data, energies []float64
+	add := func(x ...float64) {
+		data = append(data, x...)
+		energies = append(energies, float64(len(energies)))
+	}
+	for i := -25; i < 25; i++ {
+		for j := -25; j < 25; j++ {
+			x := []float64{0.005, 0.005 * float64(i), 0.005 * float64(j)}
+			add(x...)
+			switch (i + j) % 4 {
+			case 0:
+				add(x[0], x[1]+0.9e-8, x[2]-0.9e-8)
+			case 1:
+				add(x[0], x[1], x[2]+1.1e-8)
+			case 2:
+				add(x[0]-0.6e-8, x[1]+0.6e-8, x[2])
+				add(x[0]+0.6e-8, x[1], x[2])
+			}
+		}
+	}
+	disps := mat.NewDense(len(energies), 3, data)
+	got := FindDuplicates(disps, energies)
+	want := naiveDuplicates(disps, energies)
+	if len(want) == 0 || fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %d sets, wanted %d\n", len(got), len(want))
+	}
+}
+
+func TestReadInputDuplicates(t *testing.T) {
+	Quiet = true
+	defer func() {
+		Quiet = false
+		DUPS = KEEP
+	}()
+	data, err := os.ReadFile("testfiles/anpass.in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// repeat the first point with a slightly different energy
+	first := " -0.00500000 -0.00500000 -0.01000000      0.000128387078\n"
+	input := strings.Replace(string(data), first,
+		first+" -0.00500000 -0.00500000 -0.01000000      0.000128387080\n",
+		1)
+	infile := filepath.Join(t.TempDir(), "dups.in")
+	if err := os.WriteFile(infile, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dups := ReadDuplicates(infile)
+	if len(dups) != 1 || fmt.Sprint(dups[0].Points) != "[0 1]" ||
+		!nearby(dups[0].Spread(), 2e-12, 1e-18) {
+		t.Fatalf("got %v\n", dups)
+	}
+	disps, energies, exps, _, _ := ReadInput("testfiles/anpass.in")
+	want, _ := Fit(disps, energies, exps)
+	tests := []struct {
+		policy DupPolicy
+		pts    int
+		e0     float64
+	}{
+		{KEEP, 70, 0.000128387078},
+		{MERGE, 69, 0.000128387078},
+		{AVERAGE, 69, 0.000128387079},
+		{REJECT, 68, 0.000027809414},
+	}
+	for _, test := range tests {
+		DUPS = test.policy
+		d, e, _, _, _ := ReadInput(infile)
+		if r, _ := d.Dims(); r != test.pts || len(e) != test.pts {
+			t.Errorf("policy %d: got %d points, wanted %d\n",
+				test.policy, r, test.pts)
+		}
+		if !nearby(e[0], test.e0, 1e-15) {
+			t.Errorf("policy %d: got %.12f, wanted %.12f\n",
+				test.policy, e[0], test.e0)
+		}
+		if test.policy == MERGE {
+			got, _ := Fit(d, e, exps)
+			if !mat.Equal(got, want) {
+				t.Error("merged fit differs from the original")
+			}
+		}
+	}
+}
@@ -170,3 +170,65 @@ func BenchmarkNewton(b *testing.B) {
 		Newton(coeffs, exps)
 	}
 }
+
+func TestNewtonFrozen(t *testing.T) {
+	disps, energies, exps, _, _ := ReadInput("full_tests/c3h2.in")
+	coeffs, _ := Fit(disps, energies, exps)
+	got := NewtonFrozen(coeffs, exps, Freeze(9, 5, 6, 7, 8, 9))
+	want := []float64{
+		-0.000124209618, 0.000083980449, -0.000036821098,
+		-0.000117696241, 0, 0, 0, 0, 0,
+	}
+	if !eql(got, want, 1e-12) {
+		t.Errorf("got %v, wanted %v\n", got, want)
+	}
+	for _, v := range got[4:] {
+		if v != 0 {
+			t.Errorf("got %v, wanted exactly zero\n", got[4:])
+			break
+		}
+	}
+}
+
+func TestRunIter(t *testing.T) {
+	Quiet = true
+	defer func() {
+		Quiet = false
+	}()
+	disps, energies, exps, biases, _ := ReadInput("full_tests/h2o.in")
+	disps, energies = Bias(disps, energies, biases)
+	longLine, got, converged := RunIter(io.Discard, t.TempDir(),
+		disps, energies, exps)
+	if !converged {
+		t.Error("RunIter did not converge")
+	}
+	lline := []float64{
+		-0.000045311426, -0.000027076533,
+		0.000000000000, -0.000000002131,
+	}
+	if !eql(longLine, lline, 1e-12) {
+		t.Errorf("got %v, wanted %v\n", longLine, lline)
+	}
+	if !compFC(got, load9903("full_tests/h2o.9903"), 6e-9) {
+		t.Error("force constants mismatch")
+	}
+}
+
+func TestRunStationary(t *testing.T) {
+	tests := []struct {
+		infile string
+		want   bool
+	}{
+		{"testfiles/anpass.in", false},
+		{"testfiles/anpass2.in", true},
+	}
+	for _, test := range tests {
+		disps, energies, exps, biases, _ := ReadInput(test.infile)
+		disps, energies = Bias(disps, energies, biases)
+		_, _, got := Run(io.Discard, t.TempDir(), disps, energies, exps)
+		if got != test.want {
+			t.Errorf("%s: got %v, wanted %v\n",
+				test.infile, got, test.want)
+		}
+	}
+}
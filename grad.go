@@ -0,0 +1,85 @@
+package anpass
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// FitGrad is like Fit, but also fits the gradient at each point in the
+// rows of grads. Each gradient component adds a row to the least-squares
+// system containing the derivatives of the monomials in exps with
+// respect to its coordinate, with the row and the gradient weighted by
+// GRADWT, which must be positive, relative to the energies. The returned
+// design matrix holds the energy rows followed by the weighted gradient
+// rows, point by point
+func FitGrad(disps *mat.Dense, energies []float64, grads *mat.Dense,
+	exps [][]int) (soln, fn *mat.Dense) {
+	// PrintGradResiduals also divides by GRADWT to undo the weighting
+	if !(GRADWT > 0) {
+		panic(fmt.Sprintf("GRADWT must be positive, got %g", GRADWT))
+	}
+	_, coeffs := Dims(exps)
+	pts, nvbl := disps.Dims()
+	X := mat.NewDense(pts*(nvbl+1), coeffs, nil)
+	ne := buildNormal(X.Slice(0, pts, 0, coeffs).(*mat.Dense), disps,
+		energies, exps)
+	s := compileDesign(exps)
+	for i := 0; i < pts; i++ {
+		for j := 0; j < nvbl; j++ {
+			row := X.RawRowView(pts + i*nvbl + j)
+			s.gradDesign(disps.RawRowView(i), j, row)
+			for k := range row {
+				row[k] *= GRADWT
+			}
+			ne.add(row, GRADWT*grads.At(i, j))
+		}
+	}
+	return ne.solve(Constraints, Quiet), X
+}
+
+// PrintGradResiduals is like PrintResiduals for a fit from FitGrad,
+// printing the energy residuals followed by the residual of each
+// gradient component, and returns the weighted sum of squared residuals
+// of both
+func PrintGradResiduals(w io.Writer, x, A *mat.Dense, energies []float64,
+	grads *mat.Dense) (sum float64) {
+	pts, nvbl := grads.Dims()
+	var prod mat.Dense
+	prod.Mul(A, x)
+	computed := prod.RawMatrix().Data
+	sum = printResiduals(w, computed[:pts], energies)
+	fmt.Fprintf(w, "\n%5s%6s%20s%20s%20s\n",
+		"POINT", "COORD", "COMPUTED", "OBSERVED", "RESIDUAL")
+	var gsum float64
+	for i := 0; i < pts; i++ {
+		for j := 0; j < nvbl; j++ {
+			comp := computed[pts+i*nvbl+j] / GRADWT
+			obsv := grads.At(i, j)
+			resi := comp - obsv
+			fmt.Fprintf(w, "%5d%6d%20.12f%20.12f%20.8E\n",
+				i+1, j+1, comp, obsv, resi)
+			gsum += GRADWT * GRADWT * resi * resi
+		}
+	}
+	fmt.Fprintf(w, "WEIGHTED SUM OF SQUARED GRADIENT RESIDUALS IS %17.8E\n",
+		gsum)
+	sum += gsum
+	fmt.Fprintf(w, "TOTAL WEIGHTED SUM OF SQUARED RESIDUALS IS %17.8E\n", sum)
+	return
+}
+
+// RunGrad is like Run, but fits the gradients in grads along with the
+// energies using FitGrad
+func RunGrad(w io.Writer, dir string, disps *mat.Dense, energies []float64,
+	grads *mat.Dense, exps [][]int) (longLine []float64, fcs []FC,
+	stationary bool) {
+	coeffs, fn := FitGrad(disps, energies, grads, exps)
+	PrintGradResiduals(w, coeffs, fn, energies, grads)
+	PrintConstraints(w, coeffs, exps)
+	fcs = Write9903(filepath.Join(dir, "fort.9903"), coeffs, exps)
+	longLine = locate(w, coeffs, exps)
+	return longLine, fcs, atOrigin(longLine)
+}
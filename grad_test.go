@@ -0,0 +1,61 @@
+package anpass
+
+import (
+	"io"
+	"math"
+	"testing"
+)
+
+func TestFitGrad(t *testing.T) {
+	Quiet = true
+	defer func() {
+		Quiet = false
+	}()
+	disps, energies, exps, _, _ := ReadInput("testfiles/grad.in")
+	grads := ReadGradients("testfiles/grad.in")
+	if r, c := grads.Dims(); r != 25 || c != 3 {
+		t.Fatalf("got %dx%d gradients, wanted 25x3\n", r, c)
+	}
+	// the energies alone cannot determine every term
+	if d := CheckDesign(disps, exps); d.Rank == d.Terms {
+		t.Fatal("expected energies alone to be rank deficient")
+	}
+	// the data was generated from the fit to anpass.in and rounded to 12
+	// decimal places, which limits the agreement of the quartic terms
+	d, e, _, _, _ := ReadInput("testfiles/anpass.in")
+	want, _ := Fit(d, e, exps)
+	got, fn := FitGrad(disps, energies, grads, exps)
+	for k := range exps[0] {
+		if w := want.At(k, 0); !nearby(got.At(k, 0), w,
+			math.Max(1e-4*math.Abs(w), THR)) {
+			t.Errorf("term %d: got %g, wanted %g\n",
+				k, got.At(k, 0), want.At(k, 0))
+		}
+	}
+	if sum := PrintGradResiduals(io.Discard, got, fn, energies,
+		grads); sum > 1e-20 {
+		t.Errorf("got sum of squared residuals %g, wanted 0\n", sum)
+	}
+	if g := ReadGradients("testfiles/anpass.in"); g != nil {
+		t.Error("got gradients from input without them")
+	}
+}
+
+func TestGradWeight(t *testing.T) {
+	defer func() {
+		GRADWT = 1.0
+	}()
+	disps, energies, exps, _, _ := ReadInput("testfiles/grad.in")
+	for _, w := range []float64{0, -1, math.NaN()} {
+		GRADWT = w
+		grads := ReadGradients("testfiles/grad.in")
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("fit gradients with GRADWT %g\n", w)
+				}
+			}()
+			FitGrad(disps, energies, grads, exps)
+		}()
+	}
+}
@@ -0,0 +1,169 @@
+package anpass
+
+import (
+	"sort"
+	"strings"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// PointGroup is the character table of an abelian point group, giving
+// the character of each irrep, by its lowercase label, under each
+// operation of the group
+type PointGroup map[string][]int
+
+// PointGroups are the abelian point groups, by their lowercase names
+var PointGroups = map[string]PointGroup{
+	"c1": {"a": {1}},
+	"cs": {"a'": {1, 1}, "a''": {1, -1}},
+	"ci": {"ag": {1, 1}, "au": {1, -1}},
+	"c2": {"a": {1, 1}, "b": {1, -1}},
+	// E C2 sv(xz) sv(yz)
+	"c2v": {
+		"a1": {1, 1, 1, 1}, "a2": {1, 1, -1, -1},
+		"b1": {1, -1, 1, -1}, "b2": {1, -1, -1, 1},
+	},
+	// E C2 i sh
+	"c2h": {
+		"ag": {1, 1, 1, 1}, "bg": {1, -1, 1, -1},
+		"au": {1, 1, -1, -1}, "bu": {1, -1, -1, 1},
+	},
+	// E C2(z) C2(y) C2(x)
+	"d2": {
+		"a": {1, 1, 1, 1}, "b1": {1, 1, -1, -1},
+		"b2": {1, -1, 1, -1}, "b3": {1, -1, -1, 1},
+	},
+	// E C2(z) C2(y) C2(x) i s(xy) s(xz) s(yz)
+	"d2h": {
+		"ag":  {1, 1, 1, 1, 1, 1, 1, 1},
+		"b1g": {1, 1, -1, -1, 1, 1, -1, -1},
+		"b2g": {1, -1, 1, -1, 1, -1, 1, -1},
+		"b3g": {1, -1, -1, 1, 1, -1, -1, 1},
+		"au":  {1, 1, 1, 1, -1, -1, -1, -1},
+		"b1u": {1, 1, -1, -1, -1, -1, 1, 1},
+		"b2u": {1, -1, 1, -1, -1, 1, -1, 1},
+		"b3u": {1, -1, -1, 1, -1, 1, 1, -1},
+	},
+}
+
+// signs returns, for each operation of g, the sign each coordinate with
+// the corresponding irrep label in irreps takes under it. A nil g has
+// only the identity
+func (g PointGroup) signs(irreps []string) [][]int {
+	if g == nil {
+		return nil
+	}
+	var ops int
+	for _, chars := range g {
+		ops = len(chars)
+	}
+	ret := make([][]int, ops)
+	for op := range ret {
+		ret[op] = make([]int, len(irreps))
+		for j, irr := range irreps {
+			chars, ok := g[strings.ToLower(irr)]
+			if !ok {
+				panic("unknown irrep " + irr)
+			}
+			ret[op][j] = chars[op]
+		}
+	}
+	return ret
+}
+
+// symmetric reports whether the monomial with exponents exps is totally
+// symmetric under every operation in signs
+func symmetric(signs [][]int, exps []int) bool {
+	for _, op := range signs {
+		prod := 1
+		for j, e := range exps {
+			if e%2 != 0 {
+				prod *= op[j]
+			}
+		}
+		if prod != 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// SymmetricExps returns the exponents, in the form of an input FUNCTION
+// block, of every totally symmetric monomial up to degree in the
+// coordinates with the irrep labels in irreps under g. The terms are
+// ordered by degree and then by ascending powers of the last coordinate,
+// the next to last, and so on, as in the usual input files
+func SymmetricExps(irreps []string, g PointGroup, degree int) [][]int {
+	signs := g.signs(irreps)
+	n := len(irreps)
+	ret := make([][]int, n)
+	for d := 0; d <= degree; d++ {
+		monos := monomials(n, d)
+		sort.SliceStable(monos, func(a, b int) bool {
+			for j := n - 1; j >= 0; j-- {
+				if monos[a][j] != monos[b][j] {
+					return monos[a][j] < monos[b][j]
+				}
+			}
+			return false
+		})
+		for _, exps := range monos {
+			if !symmetric(signs, exps) {
+				continue
+			}
+			for j, e := range exps {
+				ret[j] = append(ret[j], e)
+			}
+		}
+	}
+	return ret
+}
+
+// Grid returns the displacements needed to determine a force field of
+// the given order in the coordinates with the irrep labels in irreps
+// under g, displacing each coordinate by multiples of its step in steps.
+// These are the points of the Lattice with at most order total steps
+// whose pattern of steps, taken as exponents, is a totally symmetric
+// monomial, which for a single non-totally symmetric coordinate means
+// it is only displaced by even multiples of its step. Of the points
+// related by the operations of g, only the one that is largest when
+// compared coordinate by coordinate is kept, since they have the same
+// energy. A nil g returns every point of the Lattice
+func Grid(steps []float64, order int, irreps []string, g PointGroup) *mat.Dense {
+	signs := g.signs(irreps)
+	lattice := Lattice(steps, order)
+	r, n := lattice.Dims()
+	var data []float64
+	for i := 0; i < r; i++ {
+		disp := lattice.RawRowView(i)
+		idx, _ := stepIndex(disp, steps)
+		abs := make([]int, n)
+		for j, k := range idx {
+			if k < 0 {
+				k = -k
+			}
+			abs[j] = k
+		}
+		if !symmetric(signs, abs) || !canonical(signs, idx) {
+			continue
+		}
+		data = append(data, disp...)
+	}
+	return mat.NewDense(len(data)/n, n, data)
+}
+
+// canonical reports whether idx is at least as large as its image under
+// every operation in signs
+func canonical(signs [][]int, idx []int) bool {
+	for _, op := range signs {
+		for j, k := range idx {
+			if img := op[j] * k; img != k {
+				if img > k {
+					return false
+				}
+				break
+			}
+		}
+	}
+	return true
+}
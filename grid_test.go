@@ -0,0 +1,116 @@
+package anpass
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestSymmetricExps(t *testing.T) {
+	tests := []struct {
+		infile string
+		irreps []string
+		group  string
+	}{
+		{"testfiles/anpass.in", []string{"A1", "A1", "B2"}, "c2v"},
+		{
+			"full_tests/c3h2.in",
+			[]string{"a1", "a1", "a1", "a1", "b1", "b1", "b1", "a2", "b2"},
+			"c2v",
+		},
+	}
+	for _, test := range tests {
+		_, _, want, _, _ := ReadInput(test.infile)
+		got := SymmetricExps(test.irreps, PointGroups[test.group], 4)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, wanted %v\n", test.infile, got, want)
+		}
+	}
+}
+
+func TestGrid(t *testing.T) {
+	tests := []struct {
+		infile string
+		irreps []string
+		group  string
+		want   int
+	}{
+		{"testfiles/anpass.in", []string{"a1", "a1", "b2"}, "c2v", 55},
+		{
+			"full_tests/c3h2.in",
+			[]string{"a1", "a1", "a1", "a1", "b1", "b1", "b1", "a2", "b2"},
+			"c2v", 880,
+		},
+	}
+	for _, test := range tests {
+		disps, _, _, _, _ := ReadInput(test.infile)
+		_, n := disps.Dims()
+		steps := make([]float64, n)
+		for i := range steps {
+			steps[i] = 0.005
+		}
+		g := PointGroups[test.group]
+		grid := Grid(steps, 4, test.irreps, g)
+		gr, _ := grid.Dims()
+		if gr != test.want {
+			t.Errorf("%s: got %d points, wanted %d\n", test.infile, gr, test.want)
+		}
+		// the grid and its images under the group should be exactly the
+		// points in the input
+		want := make(map[string]bool)
+		r, _ := disps.Dims()
+		for i := 0; i < r; i++ {
+			idx, _ := stepIndex(disps.RawRowView(i), steps)
+			want[fmt.Sprint(idx)] = true
+		}
+		got := make(map[string]bool)
+		for i := 0; i < gr; i++ {
+			idx, _ := stepIndex(grid.RawRowView(i), steps)
+			for _, op := range g.signs(test.irreps) {
+				img := make([]int, len(idx))
+				for j, k := range idx {
+					img[j] = op[j] * k
+				}
+				got[fmt.Sprint(img)] = true
+			}
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s: got %d, wanted %d\n", test.infile, len(got), len(want))
+		}
+		for k := range got {
+			if !want[k] {
+				t.Errorf("%s: extra %v\n", test.infile, k)
+			}
+		}
+	}
+	steps := []float64{0.005, 0.005, 0.005}
+	if r, _ := Grid(steps, 4, nil, nil).Dims(); r != 129 {
+		t.Errorf("got %d points without symmetry, wanted 129\n", r)
+	}
+}
+
+func TestWriteInput(t *testing.T) {
+	disps, energies, exps, _, _ := ReadInput("full_tests/c3h2.in")
+	var buf bytes.Buffer
+	WriteInput(&buf, "c3h2", disps, energies, exps)
+	var (
+		gotDisps    []float64
+		gotEnergies []float64
+	)
+	in := ParseInput(&buf, func(p Point) {
+		gotDisps = append(gotDisps, p.Disp...)
+		gotEnergies = append(gotEnergies, p.Energy)
+	})
+	if !reflect.DeepEqual(in.Exps, exps) {
+		t.Error("exps differ")
+	}
+	if !mat.Equal(mat.NewDense(len(gotEnergies), in.Nvbl, gotDisps), disps) {
+		t.Error("disps differ")
+	}
+	if !reflect.DeepEqual(gotEnergies, energies) {
+		t.Error("energies differ")
+	}
+}
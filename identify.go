@@ -0,0 +1,198 @@
+package anpass
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+)
+
+// dependTol is the norm below which the part of a unit column of the
+// design matrix not spanned by the columns before it is considered zero
+// by CheckDesign, making its term dependent. Coefficients of a
+// Dependency below dependTol in magnitude are left out
+const dependTol = 1e-8
+
+// Dependency is a term that a set of points cannot distinguish from a
+// combination of earlier terms: on every point, the monomial of Term
+// equals the sum of Coeffs[i] times the monomial of Terms[i]
+type Dependency struct {
+	Term   int
+	Terms  []int
+	Coeffs []float64
+}
+
+// DesignCheck describes how well a set of points determines the terms
+// of a polynomial
+type DesignCheck struct {
+	Terms int
+	Rank  int
+	// Cond is the condition number of the design matrix with each
+	// column scaled to unit norm, which is infinite if Rank < Terms
+	Cond         float64
+	Dependencies []Dependency
+}
+
+// CheckDesign reports whether the points in disps can determine every
+// term of the polynomial given by exps, using only the design matrix.
+// The columns of the design matrix are taken in order, so each
+// Dependency is expressed in terms of the independent columns before it,
+// and lower-degree terms are kept in preference to higher ones
+func CheckDesign(disps *mat.Dense, exps [][]int) *DesignCheck {
+	pts, _ := disps.Dims()
+	_, coeffs := Dims(exps)
+	s := compileDesign(exps)
+	scale := scaleColumns(s, disps, coeffs)
+	X := mat.NewDense(pts, coeffs, nil)
+	row := make([]float64, coeffs)
+	for i := 0; i < pts; i++ {
+		s.design(disps.RawRowView(i), row)
+		floats.Mul(row, scale)
+		X.SetRow(i, row)
+	}
+	ret := &DesignCheck{Terms: coeffs, Cond: math.Inf(1)}
+	// Gram-Schmidt on the columns, keeping the orthonormal basis Q of the
+	// independent columns and R with those columns equal to Q R
+	var (
+		indep []int
+		Q     [][]float64
+		R     [][]float64
+	)
+	col := make([]float64, pts)
+	for k := 0; k < coeffs; k++ {
+		mat.Col(col, k, X)
+		proj := make([]float64, len(Q))
+		// orthogonalize twice for stability
+		for pass := 0; pass < 2; pass++ {
+			for i, q := range Q {
+				d := floats.Dot(q, col)
+				proj[i] += d
+				floats.AddScaled(col, -d, q)
+			}
+		}
+		if norm := floats.Norm(col, 2); norm >= dependTol {
+			floats.Scale(1/norm, col)
+			Q = append(Q, append([]float64(nil), col...))
+			R = append(R, append(proj, norm))
+			indep = append(indep, k)
+			continue
+		}
+		// solve R c = proj for the coefficients of the independent
+		// columns, by back substitution since R is upper triangular
+		c := make([]float64, len(proj))
+		for i := len(c) - 1; i >= 0; i-- {
+			v := proj[i]
+			for l := i + 1; l < len(c); l++ {
+				v -= R[l][i] * c[l]
+			}
+			c[i] = v / R[i][i]
+		}
+		dep := Dependency{Term: k}
+		for i, v := range c {
+			if math.Abs(v) < dependTol {
+				continue
+			}
+			j := indep[i]
+			dep.Terms = append(dep.Terms, j)
+			dep.Coeffs = append(dep.Coeffs, v*scale[j]/scale[k])
+		}
+		ret.Dependencies = append(ret.Dependencies, dep)
+	}
+	ret.Rank = len(indep)
+	if ret.Rank == coeffs && pts > 0 {
+		ret.Cond = mat.Cond(X, 2)
+	}
+	return ret
+}
+
+// Print writes the report in d to w, naming terms by their exponents in
+// exps
+func (d *DesignCheck) Print(w io.Writer, exps [][]int) {
+	fmt.Fprintf(w, "RANK OF DESIGN MATRIX: %d OF %d TERMS\n", d.Rank, d.Terms)
+	fmt.Fprintf(w, "CONDITION NUMBER: %.6E\n", d.Cond)
+	if len(d.Dependencies) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "DEPENDENT TERMS:")
+	for _, dep := range d.Dependencies {
+		fmt.Fprintf(w, "%-20s =", termString(column(exps, dep.Term)))
+		if len(dep.Terms) == 0 {
+			fmt.Fprint(w, " 0")
+		}
+		for i, j := range dep.Terms {
+			fmt.Fprintf(w, " %+.6E %s", dep.Coeffs[i],
+				termString(column(exps, j)))
+		}
+		fmt.Fprint(w, "\n")
+	}
+}
+
+// dropCheck returns the CheckDesign of the points in disps for the terms
+// in exps if DropTerms is set, and nil otherwise. A fit computes it once
+// and passes it to fitConstraints, singular, and PrintDropped
+func dropCheck(disps *mat.Dense, exps [][]int) *DesignCheck {
+	if !DropTerms {
+		return nil
+	}
+	return CheckDesign(disps, exps)
+}
+
+// fitConstraints returns the constraints on a fit with the dropCheck
+// check: the Constraints followed, if check is not nil, by fixing the
+// coefficient of each dependent term to zero
+func fitConstraints(check *DesignCheck) []Constraint {
+	if check == nil {
+		return Constraints
+	}
+	cons := append([]Constraint(nil), Constraints...)
+	for _, dep := range check.Dependencies {
+		cons = append(cons, Fix(dep.Term, 0))
+	}
+	return cons
+}
+
+// singular reports err from inverting X^T X for a fit of the points in
+// disps to the terms in exps. If X^T X is exactly singular, it panics,
+// naming the terms the points cannot determine according to check, which
+// is computed if it is nil. Otherwise it only warns like Fit, since
+// nearly every fit of unscaled monomials is ill-conditioned and the
+// design check would find nothing to name
+func singular(err error, check *DesignCheck, disps *mat.Dense,
+	exps [][]int) {
+	if !strings.Contains(err.Error(), "Inf") {
+		if !Quiet {
+			fmt.Fprintf(os.Stderr, "WARNING: %v\n", err)
+		}
+		return
+	}
+	msg := err.Error()
+	d := check
+	if d == nil {
+		d = CheckDesign(disps, exps)
+	}
+	if len(d.Dependencies) > 0 {
+		names := make([]string, len(d.Dependencies))
+		for i, dep := range d.Dependencies {
+			names[i] = termString(column(exps, dep.Term))
+		}
+		msg += fmt.Sprintf("; rank %d of %d terms, dependent terms %s",
+			d.Rank, d.Terms, strings.Join(names, ", "))
+	}
+	panic(msg)
+}
+
+// PrintDropped prints the terms dropped from a fit by DropTerms, the
+// Dependencies in check from CheckDesign, along with the combination of
+// other terms each is indistinguishable from. It prints nothing if check
+// is nil or has no Dependencies
+func PrintDropped(w io.Writer, check *DesignCheck, exps [][]int) {
+	if check == nil || len(check.Dependencies) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "DROPPED TERMS, COEFFICIENTS SET TO ZERO")
+	check.Print(w, exps)
+}
@@ -0,0 +1,135 @@
+package anpass
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestCheckDesign(t *testing.T) {
+	disps, _, exps, _, _ := ReadInput("testfiles/anpass.in")
+	d := CheckDesign(disps, exps)
+	if d.Rank != 22 || len(d.Dependencies) != 0 || math.IsInf(d.Cond, 1) {
+		t.Errorf("got %+v, wanted full rank\n", d)
+	}
+	// with x1 always +-0.01, x1^2*x2 is 1e-4 x2
+	disps = mat.NewDense(6, 2, []float64{
+		-0.01, -0.005,
+		-0.01, 0,
+		-0.01, 0.005,
+		0.01, -0.005,
+		0.01, 0,
+		0.01, 0.005,
+	})
+	exps = [][]int{
+		{0, 0, 2, 1},
+		{0, 1, 1, 0},
+	}
+	d = CheckDesign(disps, exps)
+	if d.Rank != 3 {
+		t.Errorf("got rank %d, wanted 3\n", d.Rank)
+	}
+	if !math.IsInf(d.Cond, 1) {
+		t.Errorf("got cond %g, wanted +Inf\n", d.Cond)
+	}
+	if len(d.Dependencies) != 1 {
+		t.Fatalf("got %d dependencies, wanted 1\n", len(d.Dependencies))
+	}
+	dep := d.Dependencies[0]
+	if dep.Term != 2 || len(dep.Terms) != 1 || dep.Terms[0] != 1 ||
+		!nearby(dep.Coeffs[0], 1e-4, 1e-15) {
+		t.Errorf("got %+v, wanted x1^2*x2 = 1e-4 x2\n", dep)
+	}
+	var buf bytes.Buffer
+	d.Print(&buf, exps)
+	want := "x1^2*x2              = +1.000000E-04 x2\n"
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("got\n%s, wanted it to contain\n%s", buf.String(), want)
+	}
+}
+
+// planar returns the points of testfiles/anpass.in with x3 = 0, which
+// cannot determine any term containing x3, along with those terms
+func planar(t *testing.T) (disps *mat.Dense, energies []float64,
+	exps [][]int, x3 []int) {
+	d, e, exps, _, _ := ReadInput("testfiles/anpass.in")
+	var rows []float64
+	for i := range e {
+		if row := d.RawRowView(i); row[2] == 0 {
+			rows = append(rows, row...)
+			energies = append(energies, e[i])
+		}
+	}
+	disps = mat.NewDense(len(energies), 3, rows)
+	for k := range exps[2] {
+		if exps[2][k] > 0 {
+			x3 = append(x3, k)
+		}
+	}
+	return disps, energies, exps, x3
+}
+
+func TestDropTerms(t *testing.T) {
+	Quiet = true
+	defer func() {
+		Quiet = false
+		DropTerms = false
+	}()
+	disps, energies, exps, x3 := planar(t)
+	func() {
+		defer func() {
+			r := recover()
+			if r == nil {
+				t.Fatal("expected a panic without DropTerms")
+			}
+			if msg := fmt.Sprint(r); !strings.Contains(msg, "x3^2") {
+				t.Errorf("got %q, wanted it to name x3^2\n", msg)
+			}
+		}()
+		Fit(disps, energies, exps)
+	}()
+	DropTerms = true
+	soln, _ := Fit(disps, energies, exps)
+	for _, k := range x3 {
+		if soln.At(k, 0) != 0 {
+			t.Errorf("got %g for dropped term %d, wanted 0\n",
+				soln.At(k, 0), k)
+		}
+	}
+	// the remaining coefficients are those of a fit without the x3 terms
+	var reduced [][]int
+	for _, row := range exps {
+		var r []int
+		for k, v := range row {
+			if exps[2][k] == 0 {
+				r = append(r, v)
+			}
+		}
+		reduced = append(reduced, r)
+	}
+	want, _ := Fit(disps, energies, reduced)
+	var got []float64
+	for k := range exps[2] {
+		if exps[2][k] == 0 {
+			got = append(got, soln.At(k, 0))
+		}
+	}
+	if !eql(got, mat.Col(nil, 0, want), 1e-8) {
+		t.Errorf("got %v, wanted %v\n", got, mat.Col(nil, 0, want))
+	}
+	var buf strings.Builder
+	PrintDropped(&buf, CheckDesign(disps, exps), exps)
+	if !strings.Contains(buf.String(), "DROPPED TERMS") ||
+		strings.Count(buf.String(), " = ") != len(x3) {
+		t.Errorf("got\n%s", buf.String())
+	}
+	for _, fc := range MakeFCs(soln, exps) {
+		if fc.Coord[0] == 3 && fc.Val != 0 {
+			t.Errorf("got %v for a dropped force constant\n", fc)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package anpass
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Dataset is the data points and FUNCTION block of an anpass input
+type Dataset struct {
+	Disps    *mat.Dense
+	Energies []float64
+	Exps     [][]int
+}
+
+// ReadDataset reads the Dataset in the anpass input file filename, with
+// the reference energy chosen by ref subtracted from its energies. Every
+// point is kept, since Merge handles duplicates across all of its sets.
+// A Dataset holds only the points and the FUNCTION block, so ReadDataset
+// returns an error for inputs with any other sections that would be
+// lost
+func ReadDataset(filename string, ref RefPolicy) (*Dataset, error) {
+	d := ReadData(filename, ref, KEEP)
+	var extra []string
+	if d.Gradients {
+		extra = append(extra, "GRADIENTS")
+	}
+	if len(d.Props) > 0 {
+		extra = append(extra, "PROPERTIES")
+	}
+	if d.Composite != nil {
+		extra = append(extra, "COMPOSITE")
+	}
+	if len(d.Constraints) > 0 {
+		extra = append(extra, "CONSTRAINTS")
+	}
+	if d.Stationary {
+		extra = append(extra, "STATIONARY POINT")
+	}
+	if len(extra) > 0 {
+		return nil, fmt.Errorf("%s: cannot merge inputs with %s", filename,
+			strings.Join(extra, ", "))
+	}
+	return &Dataset{Disps: d.Disps, Energies: d.Energies, Exps: d.Exps},
+		nil
+}
+
+// Merge combines sets, which must share their coordinates and FUNCTION
+// block, into a single Dataset. The energies of each set after the first
+// are shifted onto the reference of the sets before it by the mean
+// energy difference of the points they have in common, within DUPTOL, so
+// every set must share at least one point, such as the origin, with an
+// earlier one. The resulting duplicates are handled by policy. Merge also
+// returns the shift applied to each set
+func Merge(sets []*Dataset, policy DupPolicy) (merged *Dataset,
+	shifts []float64, err error) {
+	if len(sets) == 0 {
+		return nil, nil, fmt.Errorf("no inputs to merge")
+	}
+	_, nvbl := sets[0].Disps.Dims()
+	exps := sets[0].Exps
+	var (
+		data     []float64
+		energies []float64
+	)
+	for s, set := range sets {
+		if _, c := set.Disps.Dims(); c != nvbl {
+			return nil, nil, fmt.Errorf("input %d has %d coordinates, "+
+				"wanted %d", s+1, c, nvbl)
+		}
+		if err := sameExps(exps, set.Exps); err != nil {
+			return nil, nil, fmt.Errorf("input %d: %v", s+1, err)
+		}
+		var shift float64
+		if s > 0 {
+			prev := mat.NewDense(len(energies), nvbl, data)
+			var common int
+			shift, common = meanShift(prev, energies, set.Disps,
+				set.Energies)
+			if common == 0 {
+				return nil, nil, fmt.Errorf("input %d has no points in "+
+					"common with earlier inputs", s+1)
+			}
+		}
+		shifts = append(shifts, shift)
+		pts, _ := set.Disps.Dims()
+		for i := 0; i < pts; i++ {
+			data = append(data, set.Disps.RawRowView(i)...)
+			energies = append(energies, set.Energies[i]+shift)
+		}
+	}
+	disps := mat.NewDense(len(energies), nvbl, data)
+	dups := FindDuplicates(disps, energies)
+	e := mat.NewDense(len(energies), 1, energies)
+	merged = &Dataset{
+		Disps:    Resolve(disps, dups, policy),
+		Energies: Resolve(e, dups, policy).RawMatrix().Data,
+		Exps:     exps,
+	}
+	return merged, shifts, nil
+}
+
+// sameExps returns an error naming the first term that differs between
+// the FUNCTION blocks a and b, or nil if they are the same
+func sameExps(a, b [][]int) error {
+	va, na := Dims(a)
+	vb, nb := Dims(b)
+	if va != vb {
+		return fmt.Errorf("FUNCTION has %d variables, wanted %d", vb, va)
+	}
+	if na != nb {
+		return fmt.Errorf("FUNCTION has %d terms, wanted %d", nb, na)
+	}
+	for k := 0; k < na; k++ {
+		ca, cb := column(a, k), column(b, k)
+		for j := range ca {
+			if ca[j] != cb[j] {
+				return fmt.Errorf("FUNCTION term %d is %s, wanted %s",
+					k+1, termString(cb), termString(ca))
+			}
+		}
+	}
+	return nil
+}
+
+// meanShift returns the mean difference between the energies of the
+// points of prev and those of disps at the same displacement within
+// DUPTOL, and the number of such points
+func meanShift(prev *mat.Dense, prevE []float64, disps *mat.Dense,
+	energies []float64) (shift float64, common int) {
+	pts, nvbl := disps.Dims()
+	npts, _ := prev.Dims()
+	for i := 0; i < pts; i++ {
+		x := disps.RawRowView(i)
+		for j := 0; j < npts; j++ {
+			y := prev.RawRowView(j)
+			same := true
+			for k := 0; k < nvbl && same; k++ {
+				same = math.Abs(x[k]-y[k]) <= DUPTOL
+			}
+			if same {
+				shift += prevE[j] - energies[i]
+				common++
+				break
+			}
+		}
+	}
+	if common > 0 {
+		shift /= float64(common)
+	}
+	return
+}
@@ -0,0 +1,146 @@
+package anpass
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// split returns the points of set for which keep is true, with shift
+// added to their energies
+func split(set *Dataset, keep func(x []float64) bool,
+	shift float64) *Dataset {
+	var (
+		data     []float64
+		energies []float64
+	)
+	pts, nvbl := set.Disps.Dims()
+	for i := 0; i < pts; i++ {
+		if x := set.Disps.RawRowView(i); keep(x) {
+			data = append(data, x...)
+			energies = append(energies, set.Energies[i]+shift)
+		}
+	}
+	return &Dataset{
+		Disps:    mat.NewDense(len(energies), nvbl, data),
+		Energies: energies,
+		Exps:     set.Exps,
+	}
+}
+
+func TestMerge(t *testing.T) {
+	Quiet = true
+	defer func() {
+		Quiet = false
+	}()
+	full, err := ReadDataset("testfiles/anpass.in", AUTO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// both halves contain the origin
+	a := split(full, func(x []float64) bool { return x[2] == 0 }, 0)
+	b := split(full, func(x []float64) bool {
+		return x[2] != 0 || x[0] == 0 && x[1] == 0
+	}, 0.5)
+	merged, shifts, err := Merge([]*Dataset{a, b}, AVERAGE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shifts[0] != 0 || !nearby(shifts[1], -0.5, 1e-12) {
+		t.Errorf("got shifts %v, wanted [0 -0.5]\n", shifts)
+	}
+	if r, _ := merged.Disps.Dims(); r != 69 {
+		t.Errorf("got %d points, wanted 69\n", r)
+	}
+	want, _ := Fit(full.Disps, full.Energies, full.Exps)
+	got, _ := Fit(merged.Disps, merged.Energies, merged.Exps)
+	if !eql(mat.Col(nil, 0, got), mat.Col(nil, 0, want), 1e-8) {
+		t.Errorf("got %v, wanted %v\n", mat.Col(nil, 0, got),
+			mat.Col(nil, 0, want))
+	}
+	// the merged input reads back as the same points
+	var buf bytes.Buffer
+	WriteInput(&buf, "MERGED", merged.Disps, merged.Energies, merged.Exps)
+	infile := filepath.Join(t.TempDir(), "merged.in")
+	if err := os.WriteFile(infile, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	back, err := ReadDataset(infile, AUTO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mat.EqualApprox(back.Disps, merged.Disps, 1e-12) ||
+		!eql(back.Energies, merged.Energies, 1e-12) {
+		t.Error("merged input did not read back")
+	}
+}
+
+func TestMergeErrors(t *testing.T) {
+	Quiet = true
+	defer func() {
+		Quiet = false
+	}()
+	full, err := ReadDataset("testfiles/anpass.in", AUTO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origin := func(x []float64) bool {
+		return x[0] == 0 && x[1] == 0 && x[2] == 0
+	}
+	disjoint := split(full, func(x []float64) bool { return !origin(x) }, 0)
+	fewer := &Dataset{
+		Disps:    full.Disps,
+		Energies: full.Energies,
+		Exps: [][]int{
+			full.Exps[0][:5], full.Exps[1][:5], full.Exps[2][:5],
+		},
+	}
+	tests := []struct {
+		name string
+		sets []*Dataset
+		want string
+	}{
+		{"none", nil, "no inputs"},
+		{"terms", []*Dataset{full, fewer}, "5 terms"},
+		{"coords", []*Dataset{full, {
+			Disps:    mat.NewDense(1, 2, nil),
+			Energies: []float64{0},
+			Exps:     full.Exps,
+		}}, "2 coordinates"},
+		{"common", []*Dataset{split(full, origin, 0), disjoint},
+			"no points in common"},
+	}
+	for _, test := range tests {
+		_, _, err := Merge(test.sets, AVERAGE)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, wanted %q\n", test.name, err, test.want)
+		}
+	}
+}
+
+func TestReadDatasetSections(t *testing.T) {
+	Quiet = true
+	defer func() {
+		Quiet = false
+	}()
+	dir := t.TempDir()
+	tests := []struct {
+		infile string
+		want   string
+	}{
+		{"testfiles/grad.in", "GRADIENTS"},
+		{"testfiles/anpass2.in", "STATIONARY POINT"},
+		{dipzInput(t, dir), "PROPERTIES"},
+	}
+	for _, test := range tests {
+		_, err := ReadDataset(test.infile, AUTO)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, wanted %q\n", test.infile, err,
+				test.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package anpass
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+)
+
+// fitBlock is the number of rows of the design matrix built as a unit by
+// one of the goroutines in Fit
+const fitBlock = 256
+
+// normal holds the normal equations X^T X b = X^T y of a least-squares
+// fit
+type normal struct {
+	XTX *mat.SymDense
+	XTy *mat.VecDense
+}
+
+func newNormal(coeffs int) *normal {
+	return &normal{
+		XTX: mat.NewSymDense(coeffs, nil),
+		XTy: mat.NewVecDense(coeffs, nil),
+	}
+}
+
+// add adds a single row of the design matrix and its observation y to
+// the normal equations, in the same order of operations as buildNormal
+func (n *normal) add(row []float64, y float64) {
+	xtx := n.XTX.RawSymmetric()
+	xty := n.XTy.RawVector().Data
+	for k, v := range row {
+		if v == 0 {
+			continue
+		}
+		floats.AddScaled(xtx.Data[k*xtx.Stride+k:k*xtx.Stride+len(row)],
+			v, row[k:])
+		xty[k] += v * y
+	}
+}
+
+// solve returns the solution of the normal equations subject to cons,
+// warning about a singular X^T X like Fit unless quiet
+func (n *normal) solve(cons []Constraint, quiet bool) *mat.Dense {
+	if len(cons) > 0 {
+		return solveConstrained(n.XTX, n.XTy, cons, quiet)
+	}
+	var inv mat.Dense
+	err := inv.Inverse(n.XTX)
+	if err != nil {
+		if strings.Contains(err.Error(), "Inf") {
+			panic(err)
+		}
+		if !quiet {
+			fmt.Fprintf(os.Stderr, "WARNING: %v\n", err)
+		}
+	}
+	var sol mat.Dense
+	sol.Mul(&inv, n.XTy)
+	return &sol
+}
+
+// parallel calls fn(i) for each i in [0, n) on up to GOMAXPROCS
+// goroutines
+func parallel(n int, fn func(i int)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	workers := runtime.GOMAXPROCS(0)
+	if workers > n {
+		workers = n
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// buildNormal fills the rows of X with the monomials in exps evaluated
+// at each row of disps and returns the normal equations for fitting
+// energies, leaving X^T y nil if energies is nil. The rows of X are built
+// in blocks of fitBlock, and then each row of X^T X and element of X^T y
+// is accumulated by a single goroutine over the rows of X in order. Every
+// sum is therefore taken in the same order as a serial accumulation,
+// making the result independent of the number of goroutines and the same
+// as the original anpass, which matters for the nearly singular fits
+// common with symmetry.
+//
+// Giving each goroutine a block of rows and reducing the partial sums of
+// X^T X in a fixed order would also be deterministic, but it rounds
+// differently from a serial sum, and that was enough to change the force
+// constants of the c3h2 and hoof tests beyond their reference fort.9903
+// files, so the work is split over the rows of X^T X instead
+func buildNormal(X, disps *mat.Dense, energies []float64,
+	exps [][]int) *normal {
+	s := compileDesign(exps)
+	pts, _ := X.Dims()
+	parallel((pts+fitBlock-1)/fitBlock, func(b int) {
+		lo := b * fitBlock
+		hi := lo + fitBlock
+		if hi > pts {
+			hi = pts
+		}
+		for i := lo; i < hi; i++ {
+			s.design(disps.RawRowView(i), X.RawRowView(i))
+		}
+	})
+	return accumulate(X, energies, nil)
+}
+
+// accumulate returns the normal equations for fitting y with the design
+// matrix X, as described for buildNormal, with each row weighted by the
+// corresponding element of weights. A nil weights gives every row a
+// weight of one, and a nil y leaves X^T y nil
+func accumulate(X *mat.Dense, y, weights []float64) *normal {
+	pts, coeffs := X.Dims()
+	xtx := make([]float64, coeffs*coeffs)
+	var xty []float64
+	if y != nil {
+		xty = make([]float64, coeffs)
+	}
+	parallel(coeffs, func(k int) {
+		dst := xtx[k*coeffs+k : (k+1)*coeffs]
+		for i := 0; i < pts; i++ {
+			row := X.RawRowView(i)
+			v := row[k]
+			if v == 0 {
+				continue
+			}
+			if weights != nil {
+				v *= weights[i]
+			}
+			floats.AddScaled(dst, v, row[k:])
+			if xty != nil {
+				xty[k] += v * y[i]
+			}
+		}
+	})
+	ret := &normal{XTX: mat.NewSymDense(coeffs, xtx)}
+	if xty != nil {
+		ret.XTy = mat.NewVecDense(coeffs, xty)
+	}
+	return ret
+}
@@ -0,0 +1,425 @@
+package anpass
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// conversion factor from hartree to attojoules used in fort.9903 files
+const htoaj = 4.359813653e0
+
+// Polynomial is a polynomial in Nvbl variables, where Coeffs[k] is the
+// coefficient of the term whose exponent on variable j is Exps[j][k]
+type Polynomial struct {
+	Coeffs []float64
+	Exps   [][]int
+}
+
+// Term is a single term of a Polynomial
+type Term struct {
+	Coeff float64
+	Exps  []int
+}
+
+// NewPolynomial returns the Polynomial described by the coefficients
+// returned by Fit and the exponents they were fit with
+func NewPolynomial(coeffs *mat.Dense, exps [][]int) *Polynomial {
+	r, _ := coeffs.Dims()
+	c := make([]float64, r)
+	for i := range c {
+		c[i] = coeffs.At(i, 0)
+	}
+	return &Polynomial{Coeffs: c, Exps: exps}
+}
+
+// PolynomialFromFCs returns the Polynomial in nvbl variables whose
+// derivatives at the origin are the force constants in fcs, the inverse
+// of ForceConstants
+func PolynomialFromFCs(nvbl int, fcs []FC) *Polynomial {
+	p := &Polynomial{
+		Coeffs: make([]float64, len(fcs)),
+		Exps:   make([][]int, nvbl),
+	}
+	for j := range p.Exps {
+		p.Exps[j] = make([]int, len(fcs))
+	}
+	for k, fc := range fcs {
+		for _, c := range fc.Coord {
+			if c > 0 {
+				p.Exps[c-1][k]++
+			}
+		}
+		ifact := 1
+		for j := range p.Exps {
+			ifact *= factorial(p.Exps[j][k])
+		}
+		p.Coeffs[k] = fc.Val / (float64(ifact) * htoaj)
+	}
+	return p
+}
+
+// Read9903 reads the force constants from the fort.9903 file filename
+// and returns the Polynomial they describe. The number of variables is
+// taken to be the largest coordinate index in the file
+func Read9903(filename string) *Polynomial {
+	f, err := os.Open(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	var (
+		fcs  []FC
+		nvbl int
+	)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 5 {
+			continue
+		}
+		var fc FC
+		for i := range fields[:4] {
+			fc.Coord[i], err = strconv.Atoi(fields[i])
+			if err != nil {
+				panic(err)
+			}
+			if fc.Coord[i] > nvbl {
+				nvbl = fc.Coord[i]
+			}
+		}
+		fc.Val = toFloat(fields[4])[0]
+		fcs = append(fcs, fc)
+	}
+	return PolynomialFromFCs(nvbl, fcs)
+}
+
+// Nvbl returns the number of variables in p
+func (p *Polynomial) Nvbl() int {
+	return len(p.Exps)
+}
+
+// coeffMat returns the coefficients of p as a column vector in the
+// form returned by Fit
+func (p *Polynomial) coeffMat() *mat.Dense {
+	return mat.NewDense(len(p.Coeffs), 1, p.Coeffs)
+}
+
+// Eval evaluates p at x
+func (p *Polynomial) Eval(x []float64) float64 {
+	return Eval(x, p.Coeffs, p.Exps)
+}
+
+// EvalBatch evaluates p at each row of disps
+func (p *Polynomial) EvalBatch(disps *mat.Dense) []float64 {
+	r, _ := disps.Dims()
+	ret := make([]float64, r)
+	s := compile(p.Coeffs, p.Exps)
+	for i := range ret {
+		ret[i] = s.eval(disps.RawRowView(i))
+	}
+	return ret
+}
+
+// Grad returns the gradient of p at x
+func (p *Polynomial) Grad(x []float64) []float64 {
+	return Grad(x, p.coeffMat(), p.Exps)
+}
+
+// Hess returns the Hessian of p at x
+func (p *Polynomial) Hess(x []float64) *mat.SymDense {
+	return Hess(x, p.coeffMat(), p.Exps)
+}
+
+// Derivative returns the Polynomial obtained by differentiating p
+// orders[j] times with respect to variable j. Missing trailing orders
+// are taken as zero, and terms that vanish are dropped
+func (p *Polynomial) Derivative(orders ...int) *Polynomial {
+	nvbl := p.Nvbl()
+	if len(orders) > nvbl {
+		panic("too many derivative orders")
+	}
+	ret := &Polynomial{Exps: make([][]int, nvbl)}
+	for k, c := range p.Coeffs {
+		keep := true
+		for j, o := range orders {
+			e := p.Exps[j][k]
+			if e < o {
+				keep = false
+				break
+			}
+			c *= float64(factorial(e) / factorial(e-o))
+		}
+		if !keep {
+			continue
+		}
+		ret.Coeffs = append(ret.Coeffs, c)
+		for j := range ret.Exps {
+			e := p.Exps[j][k]
+			if j < len(orders) {
+				e -= orders[j]
+			}
+			ret.Exps[j] = append(ret.Exps[j], e)
+		}
+	}
+	return ret
+}
+
+// Terms returns the terms of p in order
+func (p *Polynomial) Terms() []Term {
+	ret := make([]Term, len(p.Coeffs))
+	for k, c := range p.Coeffs {
+		ret[k] = Term{Coeff: c, Exps: p.column(k)}
+	}
+	return ret
+}
+
+// column returns the exponents of term k of p
+func (p *Polynomial) column(k int) []int {
+	return column(p.Exps, k)
+}
+
+// column returns the exponents of term k in exps
+func column(exps [][]int, k int) []int {
+	ret := make([]int, len(exps))
+	for j := range exps {
+		ret[j] = exps[j][k]
+	}
+	return ret
+}
+
+// String returns p with one term per line
+func (p *Polynomial) String() string {
+	var b strings.Builder
+	for _, t := range p.Terms() {
+		fmt.Fprintf(&b, "%+20.12E %s\n", t.Coeff, termString(t.Exps))
+	}
+	return b.String()
+}
+
+// ForceConstants returns the derivatives of p at the origin in the
+// format of a fort.9903 file
+func (p *Polynomial) ForceConstants() []FC {
+	return Make9903(io.Discard, p.coeffMat(), p.Exps)
+}
+
+// DerivativeAt returns the derivative of p at x, taken orders[j] times
+// with respect to variable j
+func (p *Polynomial) DerivativeAt(x []float64, orders ...int) float64 {
+	return p.Derivative(orders...).Eval(x)
+}
+
+// Tensor returns the symmetric tensor of order-th derivatives of p at x,
+// flattened in row-major order so that for order 3 the element
+// [i][j][k] is at index (i*nvbl+j)*nvbl+k
+func (p *Polynomial) Tensor(x []float64, order int) []float64 {
+	nvbl := p.Nvbl()
+	size := 1
+	for i := 0; i < order; i++ {
+		size *= nvbl
+	}
+	ret := make([]float64, size)
+	idx := make([]int, order)
+	orders := make([]int, nvbl)
+	for flat := range ret {
+		// decode flat into idx, noting whether it is already sorted
+		sorted := true
+		for i, rem := order-1, flat; i >= 0; i-- {
+			idx[i] = rem % nvbl
+			rem /= nvbl
+			if i < order-1 && idx[i] > idx[i+1] {
+				sorted = false
+			}
+		}
+		if !sorted {
+			// the sorted permutation always comes first in row-major
+			// order, so it has already been computed
+			sort.Ints(idx)
+			canon := 0
+			for _, i := range idx {
+				canon = canon*nvbl + i
+			}
+			ret[flat] = ret[canon]
+			continue
+		}
+		for i := range orders {
+			orders[i] = 0
+		}
+		for _, i := range idx {
+			orders[i]++
+		}
+		ret[flat] = p.DerivativeAt(x, orders...)
+	}
+	return ret
+}
+
+// Cubic returns the tensor of third derivatives of p at x, as described
+// in Tensor
+func (p *Polynomial) Cubic(x []float64) []float64 {
+	return p.Tensor(x, 3)
+}
+
+// Quartic returns the tensor of fourth derivatives of p at x, as
+// described in Tensor
+func (p *Polynomial) Quartic(x []float64) []float64 {
+	return p.Tensor(x, 4)
+}
+
+// ForceConstantsAt is like ForceConstants, but returns the derivatives
+// of p at x instead of at the origin. At the stationary point found by
+// Newton, these are the force constants a refit about that point would
+// give if its function is closed under shifting the origin
+func (p *Polynomial) ForceConstantsAt(x []float64) []FC {
+	c := make([]float64, len(p.Coeffs))
+	for k := range c {
+		orders := p.column(k)
+		ifact := 1
+		for _, o := range orders {
+			ifact *= factorial(o)
+		}
+		c[k] = p.DerivativeAt(x, orders...) / float64(ifact)
+	}
+	return Make9903(io.Discard, mat.NewDense(len(c), 1, c), p.Exps)
+}
+
+// Truncation selects the terms kept by Shift
+type Truncation int
+
+const (
+	// SameTerms keeps only the terms already present in the Polynomial
+	SameTerms Truncation = iota
+	// AllTerms also keeps the lower-order terms generated by the shift
+	AllTerms
+)
+
+// Shift returns the Polynomial q with q(y) = p(y + x0), found exactly by
+// binomial expansion of each term of p about x0. trunc determines
+// whether the new lower-order terms are kept; any are appended after
+// the terms of p
+func (p *Polynomial) Shift(x0 []float64, trunc Truncation) *Polynomial {
+	nvbl := p.Nvbl()
+	ret := &Polynomial{Exps: make([][]int, nvbl)}
+	index := make(map[string]int)
+	add := func(exps []int) {
+		index[fmt.Sprint(exps)] = len(ret.Coeffs)
+		ret.Coeffs = append(ret.Coeffs, 0)
+		for j, e := range exps {
+			ret.Exps[j] = append(ret.Exps[j], e)
+		}
+	}
+	for k := range p.Coeffs {
+		add(p.column(k))
+	}
+	beta := make([]int, nvbl)
+	for k, c := range p.Coeffs {
+		alpha := p.column(k)
+		for j := range beta {
+			beta[j] = 0
+		}
+		// loop over every beta <= alpha like an odometer
+		for {
+			v := c
+			for j, a := range alpha {
+				b := beta[j]
+				if a > b {
+					v *= float64(binomial(a, b)) *
+						math.Pow(x0[j], float64(a-b))
+				}
+			}
+			i, ok := index[fmt.Sprint(beta)]
+			if !ok && trunc == AllTerms && v != 0 {
+				add(beta)
+				i, ok = len(ret.Coeffs)-1, true
+			}
+			if ok {
+				ret.Coeffs[i] += v
+			}
+			j := 0
+			for ; j < nvbl; j++ {
+				if beta[j] < alpha[j] {
+					beta[j]++
+					break
+				}
+				beta[j] = 0
+			}
+			if j == nvbl {
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// PrintShift prints a comparison between the coefficients of p shifted
+// to the stationary point in longLine and those of refit, the
+// Polynomial from refitting the data biased by longLine. The constant
+// term of the shift is taken relative to the energy in longLine, and
+// terms generated by the shift but missing from refit are marked
+func PrintShift(w io.Writer, p, refit *Polynomial, longLine []float64) {
+	last := len(longLine) - 1
+	shifted := p.Shift(longLine[:last], AllTerms)
+	index := make(map[string]int)
+	for k := range refit.Coeffs {
+		index[fmt.Sprint(refit.column(k))] = k
+	}
+	fmt.Fprintln(w, "COMPARISON OF SHIFTED AND REFIT COEFFICIENTS")
+	fmt.Fprintf(w, "%-20s%20s%20s%20s\n",
+		"TERM", "SHIFTED", "REFIT", "DIFFERENCE")
+	for _, t := range shifted.Terms() {
+		s := termString(t.Exps)
+		if s == "1" {
+			t.Coeff -= longLine[last]
+		}
+		k, ok := index[fmt.Sprint(t.Exps)]
+		if !ok {
+			fmt.Fprintf(w, "%-20s%20.12E%20s\n", s, t.Coeff, "NOT FIT")
+			continue
+		}
+		r := refit.Coeffs[k]
+		fmt.Fprintf(w, "%-20s%20.12E%20.12E%20.8E\n",
+			s, t.Coeff, r, t.Coeff-r)
+	}
+}
+
+// Write9903 writes the force constants of p to the fort.9903 file
+// filename and returns them
+func (p *Polynomial) Write9903(filename string) []FC {
+	return Write9903(filename, p.coeffMat(), p.Exps)
+}
+
+// termString returns a string like x1^2*x3 for the monomial with
+// exponents exps, or 1 for the constant term
+func termString(exps []int) string {
+	var parts []string
+	for j, e := range exps {
+		switch {
+		case e == 1:
+			parts = append(parts, fmt.Sprintf("x%d", j+1))
+		case e > 1:
+			parts = append(parts, fmt.Sprintf("x%d^%d", j+1, e))
+		}
+	}
+	if len(parts) == 0 {
+		return "1"
+	}
+	return strings.Join(parts, "*")
+}
+
+func factorial(n int) int {
+	ret := 1
+	for i := 2; i <= n; i++ {
+		ret *= i
+	}
+	return ret
+}
+
+func binomial(n, k int) int {
+	return factorial(n) / (factorial(k) * factorial(n-k))
+}
@@ -0,0 +1,172 @@
+package anpass
+
+import (
+	"io"
+	"testing"
+)
+
+func TestPolynomial(t *testing.T) {
+	disps, energies, exps, _, _ := ReadInput("testfiles/anpass.in")
+	coeffs, _ := Fit(disps, energies, exps)
+	p := NewPolynomial(coeffs, exps)
+	x := []float64{0.01, -0.005, 0.02}
+	if got, want := p.Eval(x),
+		Eval(x, coeffs.RawMatrix().Data, exps); got != want {
+		t.Errorf("Eval: got %v, wanted %v\n", got, want)
+	}
+	deepError(t, p.Grad(x), Grad(x, coeffs, exps))
+	deepError(t, p.Hess(x), Hess(x, coeffs, exps))
+	batch := p.EvalBatch(disps)
+	if !eql(batch, energies, 1e-8) {
+		t.Errorf("EvalBatch: got %v, wanted %v\n", batch, energies)
+	}
+	if !compFC(p.ForceConstants(), Make9903(io.Discard, coeffs, exps),
+		0) {
+		t.Error("ForceConstants mismatch")
+	}
+	terms := p.Terms()
+	deepError(t, terms[7], Term{p.Coeffs[7], []int{3, 0, 0}})
+}
+
+func TestDerivative(t *testing.T) {
+	// 1 + 2x1 + 3x1^2x2 + 4x2^3
+	p := &Polynomial{
+		Coeffs: []float64{1, 2, 3, 4},
+		Exps: [][]int{
+			{0, 1, 2, 0},
+			{0, 0, 1, 3},
+		},
+	}
+	tests := []struct {
+		orders []int
+		want   *Polynomial
+	}{
+		{
+			orders: []int{1},
+			want: &Polynomial{
+				Coeffs: []float64{2, 6},
+				Exps:   [][]int{{0, 1}, {0, 1}},
+			},
+		},
+		{
+			orders: []int{0, 2},
+			want: &Polynomial{
+				Coeffs: []float64{24},
+				Exps:   [][]int{{0}, {1}},
+			},
+		},
+		{
+			orders: []int{2, 1},
+			want: &Polynomial{
+				Coeffs: []float64{6},
+				Exps:   [][]int{{0}, {0}},
+			},
+		},
+	}
+	for _, test := range tests {
+		deepError(t, p.Derivative(test.orders...), test.want)
+	}
+	deepError(t, p.String(), ""+
+		" +1.000000000000E+00 1\n"+
+		" +2.000000000000E+00 x1\n"+
+		" +3.000000000000E+00 x1^2*x2\n"+
+		" +4.000000000000E+00 x2^3\n")
+}
+
+func TestRead9903(t *testing.T) {
+	p := Read9903("full_tests/h2o.9903")
+	if got := p.Nvbl(); got != 3 {
+		t.Errorf("got %d variables, wanted 3\n", got)
+	}
+	want := load9903("full_tests/h2o.9903")
+	if !compFC(p.ForceConstants(), want, 1e-12) {
+		t.Error("ForceConstants mismatch")
+	}
+	disps, energies, exps, _, _ := ReadInput("testfiles/anpass.in")
+	coeffs, _ := Fit(disps, energies, exps)
+	fit := NewPolynomial(coeffs, exps)
+	round := PolynomialFromFCs(3, fit.ForceConstants())
+	if !eql(round.Coeffs, fit.Coeffs, 1e-15) {
+		t.Errorf("got %v, wanted %v\n", round.Coeffs, fit.Coeffs)
+	}
+	deepError(t, round.Exps, exps)
+}
+
+func TestForceConstantsAt(t *testing.T) {
+	disps, energies, exps, biases, _ := ReadInput("full_tests/h2o.in")
+	disps, energies = Bias(disps, energies, biases)
+	coeffs, _ := Fit(disps, energies, exps)
+	p := NewPolynomial(coeffs, exps)
+	x := Newton(coeffs, exps)
+	got := p.ForceConstantsAt(x)
+	// refit about the stationary point
+	longLine := append(x, p.Eval(x))
+	disps, energies = Bias(disps, energies, longLine)
+	coeffs, _ = Fit(disps, energies, exps)
+	want := MakeFCs(coeffs, exps)
+	if !compFC(got[1:], want[1:], 1e-6) {
+		t.Error("analytic force constants differ from refit")
+	}
+	if !compFC(p.ForceConstantsAt(make([]float64, 3)),
+		p.ForceConstants(), THR) {
+		t.Error("ForceConstantsAt origin differs from ForceConstants")
+	}
+}
+
+func TestTensor(t *testing.T) {
+	disps, energies, exps, _, _ := ReadInput("testfiles/anpass.in")
+	coeffs, _ := Fit(disps, energies, exps)
+	p := NewPolynomial(coeffs, exps)
+	x := []float64{0.01, -0.005, 0.02}
+	cubic := p.Cubic(x)
+	if got, want := cubic[(0*3+1)*3+1], p.DerivativeAt(x, 1, 2); got != want {
+		t.Errorf("got %v, wanted %v\n", got, want)
+	}
+	if cubic[(1*3+0)*3+1] != cubic[(1*3+1)*3+0] {
+		t.Error("cubic tensor not symmetric")
+	}
+	quartic := p.Quartic(x)
+	if got, want := quartic[((2*3+0)*3+2)*3+0],
+		p.DerivativeAt(x, 2, 0, 2); got != want {
+		t.Errorf("got %v, wanted %v\n", got, want)
+	}
+	hess := p.Hess(x)
+	second := p.Tensor(x, 2)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			if !nearby(second[i*3+j], hess.At(i, j), 1e-12) {
+				t.Errorf("got %v, wanted %v\n",
+					second[i*3+j], hess.At(i, j))
+			}
+		}
+	}
+}
+
+func TestShift(t *testing.T) {
+	disps, energies, exps, biases, _ := ReadInput("full_tests/h2o.in")
+	disps, energies = Bias(disps, energies, biases)
+	coeffs, _ := Fit(disps, energies, exps)
+	p := NewPolynomial(coeffs, exps)
+	x0 := []float64{0.003, -0.002, 0.001}
+	all := p.Shift(x0, AllTerms)
+	if got := len(all.Coeffs); got <= len(p.Coeffs) {
+		t.Errorf("got %d terms, wanted more than %d\n",
+			got, len(p.Coeffs))
+	}
+	for _, y := range [][]float64{
+		{0, 0, 0},
+		{0.01, 0.005, -0.01},
+		{-0.02, 0.01, 0.005},
+	} {
+		want := p.Eval([]float64{y[0] + x0[0], y[1] + x0[1], y[2] + x0[2]})
+		if got := all.Eval(y); !nearby(got, want, 1e-11) {
+			t.Errorf("got %v, wanted %v\n", got, want)
+		}
+	}
+	// the same terms should match the derivatives at x0
+	same := p.Shift(x0, SameTerms)
+	deepError(t, same.Exps, p.Exps)
+	if !compFC(same.ForceConstants(), p.ForceConstantsAt(x0), 1e-10) {
+		t.Error("shifted force constants differ from analytic")
+	}
+}
@@ -0,0 +1,118 @@
+package anpass
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// MakeProps writes the derivatives at the origin of each property fit by
+// column k+1 of coeffs, after the energy in column 0, to w in the format
+// of a fort.9903 file, with one column of derivatives per property after
+// a header naming them. Unlike Make9903, the derivatives are left in the
+// units of the properties. MakeProps returns the derivatives of each
+// property
+func MakeProps(w io.Writer, coeffs *mat.Dense, exps [][]int,
+	names []string) [][]FC {
+	return makeParts(w, coeffs, exps, names, 1)
+}
+
+// makeParts is MakeProps with the derivatives multiplied by unit
+func makeParts(w io.Writer, coeffs *mat.Dense, exps [][]int,
+	names []string, unit float64) (ret [][]FC) {
+	ret = make([][]FC, len(names))
+	fmt.Fprintf(w, "#%4s%5s%5s%5s", "I", "J", "K", "L")
+	for _, name := range names {
+		fmt.Fprintf(w, "%20s", name)
+	}
+	fmt.Fprint(w, "\n")
+	nvbl, terms := Dims(exps)
+	for i := 0; i < terms; i++ {
+		ifact := 1
+		var ictmp [4]int
+		iccount := 0
+		for j := nvbl - 1; j >= 0; j-- {
+			iexpo := exps[j][i]
+			ifact *= factorial(iexpo)
+			for k := 0; k < iexpo; k++ {
+				ictmp[iccount+k] = j + 1
+			}
+			iccount += iexpo
+		}
+		for _, f := range ictmp {
+			fmt.Fprintf(w, "%5d", f)
+		}
+		for p := range names {
+			v := coeffs.At(i, p+1) * float64(ifact) * unit
+			fmt.Fprintf(w, "%20.12f", v)
+			ret[p] = append(ret[p], FC{ictmp, v})
+		}
+		fmt.Fprint(w, "\n")
+	}
+	return
+}
+
+// WriteProps writes the derivatives from MakeProps to filename
+func WriteProps(filename string, coeffs *mat.Dense, exps [][]int,
+	names []string) [][]FC {
+	return writeParts(filename, coeffs, exps, names, 1)
+}
+
+// writeParts writes the derivatives from makeParts to filename
+func writeParts(filename string, coeffs *mat.Dense, exps [][]int,
+	names []string, unit float64) [][]FC {
+	f, err := os.Create(filename)
+	defer f.Close()
+	if err != nil {
+		panic(err)
+	}
+	return makeParts(f, coeffs, exps, names, unit)
+}
+
+// RunProps is like Run, but also fits the properties named by names in
+// the columns of props, sharing one factorization with the energies. The
+// residuals and coefficients of each property are printed after the
+// energy results, and their derivatives are written to props.9903 in dir
+func RunProps(w io.Writer, dir string, disps *mat.Dense, energies []float64,
+	props *mat.Dense, names []string, exps [][]int) (longLine []float64,
+	fcs []FC, stationary bool) {
+	return runParts(w, dir, disps, energies, props, names, exps,
+		"props.9903", 1)
+}
+
+// runParts is RunProps with the derivatives of the properties multiplied
+// by unit and written to partFile instead
+func runParts(w io.Writer, dir string, disps *mat.Dense, energies []float64,
+	parts *mat.Dense, names []string, exps [][]int, partFile string,
+	unit float64) (longLine []float64, fcs []FC, stationary bool) {
+	pts, nparts := parts.Dims()
+	Y := mat.NewDense(pts, nparts+1, nil)
+	Y.SetCol(0, energies)
+	for p := 0; p < nparts; p++ {
+		Y.SetCol(p+1, mat.Col(nil, p, parts))
+	}
+	check := dropCheck(disps, exps)
+	coeffs, fn := fitMulti(disps, Y, exps, check)
+	var computed mat.Dense
+	computed.Mul(fn, coeffs)
+	printResiduals(w, mat.Col(nil, 0, &computed), energies)
+	_, terms := Dims(exps)
+	energy := mat.NewDense(terms, 1, mat.Col(nil, 0, coeffs))
+	PrintConstraints(w, energy, exps)
+	PrintDropped(w, check, exps)
+	fcs = Write9903(filepath.Join(dir, "fort.9903"), energy, exps)
+	longLine = locate(w, energy, exps)
+	for p, name := range names {
+		fmt.Fprintf(w, "\nPROPERTY %s\n", name)
+		printResiduals(w, mat.Col(nil, p+1, &computed),
+			mat.Col(nil, p, parts))
+		fmt.Fprintf(w, "COEFFICIENTS OF %s\n", name)
+		poly := &Polynomial{Coeffs: mat.Col(nil, p+1, coeffs), Exps: exps}
+		fmt.Fprint(w, poly)
+	}
+	writeParts(filepath.Join(dir, partFile), coeffs, exps, names, unit)
+	return longLine, fcs, atOrigin(longLine)
+}
@@ -0,0 +1,148 @@
+package anpass
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// dipz is a property in the span of the terms in testfiles/anpass.in
+func dipz(x []float64) float64 {
+	return 0.7 + 1.5*x[0] - 2*x[1] + 30*x[0]*x[0] + 4*x[2]*x[2]
+}
+
+// writePropInput writes testfiles/anpass.in to a file in dir with the
+// properties named by names, whose values at each point are returned by
+// fn given the displacements and energy, and any extra lines before the
+// data. It returns the name of the file
+func writePropInput(t *testing.T, dir string, names []string,
+	fn func(x []float64, e float64) []float64, extra ...string) string {
+	in, err := os.Open("testfiles/anpass.in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	filename := filepath.Join(dir, "props.in")
+	out, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	scanner := bufio.NewScanner(in)
+	data := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.Contains(line, "DATA POINTS"):
+			fmt.Fprintf(out, "PROPERTIES\n %s\n", strings.Join(names, " "))
+			for _, e := range extra {
+				fmt.Fprintln(out, e)
+			}
+		case strings.Contains(line, "("):
+			data = true
+			fmt.Fprintln(out, line)
+			continue
+		case strings.Contains(line, "UNKNOWNS"):
+			data = false
+		}
+		if data {
+			f := toFloat(strings.Fields(line)...)
+			for _, v := range fn(f[:3], f[3]) {
+				line += fmt.Sprintf("%20.12f", v)
+			}
+		}
+		fmt.Fprintln(out, line)
+	}
+	return filename
+}
+
+// dipzInput writes testfiles/anpass.in with the properties DIPZ and
+// ECORR, a tenth of the energy, to dir
+func dipzInput(t *testing.T, dir string) string {
+	return writePropInput(t, dir, []string{"DIPZ", "ECORR"},
+		func(x []float64, e float64) []float64 {
+			return []float64{dipz(x), e / 10}
+		})
+}
+
+func TestFitMulti(t *testing.T) {
+	Quiet = true
+	defer func() {
+		Quiet = false
+	}()
+	dir := t.TempDir()
+	infile := dipzInput(t, dir)
+	disps, energies, exps, _, _ := ReadInput(infile)
+	names, props := ReadProps(infile)
+	if fmt.Sprint(names) != "[DIPZ ECORR]" {
+		t.Fatalf("got names %v\n", names)
+	}
+	d, e, _, _, _ := ReadInput("testfiles/anpass.in")
+	if !mat.Equal(d, disps) || !eql(e, energies, 0) {
+		t.Fatal("properties changed the points")
+	}
+	want, _ := Fit(disps, energies, exps)
+	Y := mat.NewDense(len(energies), 3, nil)
+	Y.SetCol(0, energies)
+	Y.SetCol(1, mat.Col(nil, 0, props))
+	Y.SetCol(2, mat.Col(nil, 1, props))
+	got, _ := FitMulti(disps, Y, exps)
+	// the energy column must match Fit exactly
+	if !eql(mat.Col(nil, 0, got), mat.Col(nil, 0, want), 0) {
+		t.Error("energy coefficients differ from Fit")
+	}
+	p := &Polynomial{Coeffs: mat.Col(nil, 1, got), Exps: exps}
+	for _, x := range [][]float64{{0.01, -0.005, 0.01}, {0, 0.02, 0}} {
+		if v := p.Eval(x); !nearby(v, dipz(x), 1e-9) {
+			t.Errorf("got %g, wanted %g\n", v, dipz(x))
+		}
+	}
+	// and each property column must match fitting it alone
+	ecorr, _ := Fit(disps, mat.Col(nil, 1, props), exps)
+	if !eql(mat.Col(nil, 2, got), mat.Col(nil, 0, ecorr), 0) {
+		t.Error("ECORR coefficients differ from Fit")
+	}
+}
+
+func TestRunProps(t *testing.T) {
+	Quiet = true
+	defer func() {
+		Quiet = false
+	}()
+	dir := t.TempDir()
+	infile := dipzInput(t, dir)
+	disps, energies, exps, _, _ := ReadInput(infile)
+	names, props := ReadProps(infile)
+	_, fcs, _ := RunProps(io.Discard, dir, disps, energies, props, names,
+		exps)
+	_, want, _ := Run(io.Discard, dir, disps, energies, exps)
+	if !compFC(fcs, want, 0) {
+		t.Error("energy force constants differ from Run")
+	}
+	f, err := os.Open(filepath.Join(dir, "props.9903"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Scan()
+	if got := strings.Fields(scanner.Text()); fmt.Sprint(got[5:]) !=
+		"[DIPZ ECORR]" {
+		t.Errorf("got header %v\n", got)
+	}
+	// d^2 DIPZ / dx1^2 = 60
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if strings.Join(fields[:4], " ") == "1 1 0 0" {
+			if v := toFloat(fields[4])[0]; !nearby(v, 60, 1e-6) {
+				t.Errorf("got %g, wanted 60\n", v)
+			}
+		}
+	}
+}
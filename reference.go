@@ -0,0 +1,140 @@
+package anpass
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// RefPolicy is how ReadInput chooses the reference energy subtracted
+// from the energies of an input
+type RefPolicy int
+
+const (
+	// AUTO subtracts nothing from relative energies, and from absolute
+	// energies subtracts the energy of the origin, or the lowest energy
+	// if there is no point at the origin
+	AUTO RefPolicy = iota
+	// NOREF subtracts nothing
+	NOREF
+	// ORIGIN subtracts the energy of the point at the origin
+	ORIGIN
+	// MINIMUM subtracts the lowest energy
+	MINIMUM
+)
+
+// RefPolicies are the RefPolicy values by their names on the command
+// line
+var RefPolicies = map[string]RefPolicy{
+	"AUTO":    AUTO,
+	"NONE":    NOREF,
+	"ORIGIN":  ORIGIN,
+	"MINIMUM": MINIMUM,
+}
+
+// absThr is the magnitude above which AUTO takes an energy to be
+// absolute. Relative energies in a force field are far smaller than one
+// hartree, while total energies of any molecule with a heavy atom are
+// far larger
+const absThr = 1.0
+
+// parseEnergy parses the energy in s into hi, the nearest float64, and
+// lo, the rounding error of hi, so that hi+lo holds s to about twice
+// the precision of a float64
+func parseEnergy(s string) (hi, lo float64) {
+	hi = toFloat(s)[0]
+	x, _, err := big.ParseFloat(s, 10, 128, big.ToNearestEven)
+	if err != nil {
+		panic(err)
+	}
+	lo, _ = x.Sub(x, big.NewFloat(hi)).Float64()
+	return
+}
+
+// twoSum returns the float64 sum s of a and b along with its rounding
+// error e, so that s+e is exactly a+b
+func twoSum(a, b float64) (s, e float64) {
+	s = a + b
+	bb := s - a
+	e = (a - (s - bb)) + (b - bb)
+	return
+}
+
+// subtract returns (ahi+alo) - (bhi+blo) using compensated arithmetic,
+// so that nearly equal energies keep every digit of their difference
+func subtract(ahi, alo, bhi, blo float64) float64 {
+	s, e := twoSum(ahi, -bhi)
+	return s + (e + (alo - blo))
+}
+
+// Reference returns the energies of the points in disps, given by hi+lo
+// as from parseEnergy, relative to the reference energy chosen by
+// policy, along with that reference energy and whether one was
+// subtracted
+func Reference(disps *mat.Dense, hi, lo []float64, policy RefPolicy) (
+	energies []float64, ref float64, ok bool) {
+	r := refPoint(disps, hi, lo, policy)
+	if r < 0 {
+		return hi, 0, false
+	}
+	energies = make([]float64, len(hi))
+	for i := range energies {
+		energies[i] = subtract(hi[i], lo[i], hi[r], lo[r])
+	}
+	return energies, hi[r] + lo[r], true
+}
+
+// refPoint returns the index of the point whose energy Reference takes
+// as the reference energy under policy, or -1 if there is none
+func refPoint(disps *mat.Dense, hi, lo []float64, policy RefPolicy) int {
+	pts, nvbl := disps.Dims()
+	origin := -1
+	for i := 0; i < pts && origin < 0; i++ {
+		at := true
+		for k := 0; k < nvbl && at; k++ {
+			at = math.Abs(disps.At(i, k)) <= DUPTOL
+		}
+		if at {
+			origin = i
+		}
+	}
+	lowest := 0
+	absolute := false
+	for i, e := range hi {
+		if e+lo[i] < hi[lowest]+lo[lowest] {
+			lowest = i
+		}
+		absolute = absolute || math.Abs(e) > absThr
+	}
+	r := -1
+	switch policy {
+	case AUTO:
+		if !absolute {
+			break
+		}
+		r = origin
+		if r < 0 {
+			r = lowest
+		}
+	case ORIGIN:
+		if origin < 0 {
+			panic("no point at the origin to use as the reference energy")
+		}
+		r = origin
+	case MINIMUM:
+		r = lowest
+	}
+	if pts == 0 {
+		return -1
+	}
+	return r
+}
+
+// PrintReference prints the reference energy ref subtracted from the
+// energies of the input
+func PrintReference(w io.Writer, ref float64) {
+	fmt.Fprintf(w, "REFERENCE ENERGY IS %20.12f\n", ref)
+}
@@ -0,0 +1,72 @@
+package anpass
+
+import (
+	"bufio"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// absoluteInput writes testfiles/anpass.in to a file in dir with base
+// added exactly to every energy and returns the name of the file
+func absoluteInput(t *testing.T, dir, base string) string {
+	in, err := os.Open("testfiles/anpass.in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	filename := filepath.Join(dir, "abs.in")
+	out, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	b, _, _ := big.ParseFloat(base, 10, 128, big.ToNearestEven)
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 4 && strings.Contains(fields[3], ".") {
+			e, _, _ := big.ParseFloat(fields[3], 10, 128, big.ToNearestEven)
+			e.Add(e, b)
+			line = fmt.Sprintf("%s%24s", line[:36], e.Text('f', 12))
+		}
+		fmt.Fprintln(out, line)
+	}
+	return filename
+}
+
+func TestReference(t *testing.T) {
+	Quiet = true
+	defer func() {
+		Quiet = false
+		REFERENCE = AUTO
+	}()
+	_, want, _, _, _ := ReadInput("testfiles/anpass.in")
+	if _, ok := ReadReference("testfiles/anpass.in"); ok {
+		t.Error("subtracted a reference from relative energies")
+	}
+	dir := t.TempDir()
+	for _, base := range []string{"-76.123456789012", "-1234.567890123456"} {
+		infile := absoluteInput(t, dir, base)
+		for _, policy := range []RefPolicy{AUTO, ORIGIN, MINIMUM} {
+			REFERENCE = policy
+			_, got, _, _, _ := ReadInput(infile)
+			if !eql(got, want, 1e-16) {
+				t.Errorf("%s, policy %d: energies differ\n", base, policy)
+			}
+			ref, ok := ReadReference(infile)
+			if !ok || fmt.Sprintf("%.12f", ref) != base {
+				t.Errorf("%s, policy %d: got reference %.12f\n", base,
+					policy, ref)
+			}
+		}
+		REFERENCE = NOREF
+		if _, got, _, _, _ := ReadInput(infile); got[0] > -76 {
+			t.Errorf("%s: got %g with no reference\n", base, got[0])
+		}
+	}
+}
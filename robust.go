@@ -0,0 +1,209 @@
+package anpass
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Loss is the loss function minimized by Fit
+type Loss int
+
+const (
+	// LEASTSQ is the usual least-squares loss
+	LEASTSQ Loss = iota
+	// HUBER is quadratic for small residuals and linear for large ones
+	HUBER
+	// TUKEY is Tukey's bisquare, which ignores residuals beyond its
+	// cutoff entirely
+	TUKEY
+)
+
+// Losses are the Loss functions by their names on the command line
+var Losses = map[string]Loss{
+	"LEASTSQ": LEASTSQ,
+	"HUBER":   HUBER,
+	"TUKEY":   TUKEY,
+}
+
+func (l Loss) String() string {
+	for name, v := range Losses {
+		if v == l {
+			return name
+		}
+	}
+	return fmt.Sprintf("Loss(%d)", int(l))
+}
+
+const (
+	// robustTol is the largest change in any weight for FitRobust to
+	// consider the weights converged
+	robustTol = 1e-6
+	// robustIter is the maximum number of reweighting iterations in
+	// FitRobust
+	robustIter = 500
+	// madScale converts the median absolute residual to an estimate of
+	// the standard deviation of normally distributed residuals
+	madScale = 0.6744897501960817
+)
+
+// tuning returns the cutoff of l in units of the residual scale, chosen
+// for 95% efficiency on normally distributed residuals
+func (l Loss) tuning() float64 {
+	switch l {
+	case HUBER:
+		return 1.345
+	case TUKEY:
+		return 4.685
+	}
+	return math.Inf(1)
+}
+
+// weight returns the weight of a point with residual u, in units of the
+// cutoff, under l
+func (l Loss) weight(u float64) float64 {
+	u = math.Abs(u)
+	switch l {
+	case HUBER:
+		if u > 1 {
+			return 1 / u
+		}
+	case TUKEY:
+		if u >= 1 {
+			return 0
+		}
+		return (1 - u*u) * (1 - u*u)
+	}
+	return 1
+}
+
+// robustScale returns the scale of resid estimated from its median
+// absolute value
+func robustScale(resid []float64) float64 {
+	abs := make([]float64, len(resid))
+	for i, r := range resid {
+		abs[i] = math.Abs(r)
+	}
+	sort.Float64s(abs)
+	n := len(abs)
+	if n == 0 {
+		return 0
+	}
+	med := abs[n/2]
+	if n%2 == 0 {
+		med = (abs[n/2-1] + abs[n/2]) / 2
+	}
+	return med / madScale
+}
+
+// FitRobust is like Fit, but minimizes LOSS instead of the sum of
+// squared residuals by iteratively reweighted least squares, starting
+// from the least-squares fit. Since TUKEY can reject good points when
+// started far from the solution, it starts from the converged HUBER fit
+// instead. FitRobust also returns the final weight of each point, which
+// is one for every point if LOSS is LEASTSQ
+func FitRobust(disps *mat.Dense, energies []float64, exps [][]int) (
+	soln, fn *mat.Dense, weights []float64) {
+	return fitRobust(disps, energies, exps, nil)
+}
+
+// fitRobust is FitRobust, reusing check from dropCheck if it is not nil
+func fitRobust(disps *mat.Dense, energies []float64, exps [][]int,
+	check *DesignCheck) (soln, fn *mat.Dense, weights []float64) {
+	if check == nil {
+		check = dropCheck(disps, exps)
+	}
+	soln, fn = fitMulti(disps, mat.NewDense(len(energies), 1, energies),
+		exps, check)
+	weights = make([]float64, len(energies))
+	for i := range weights {
+		weights[i] = 1
+	}
+	cons := fitConstraints(check)
+	switch LOSS {
+	case HUBER:
+		soln = reweight(fn, energies, soln, weights, HUBER, cons)
+	case TUKEY:
+		soln = reweight(fn, energies, soln, weights, HUBER, cons)
+		soln = reweight(fn, energies, soln, weights, TUKEY, cons)
+	}
+	return
+}
+
+// reweight iterates the fit of energies by the design matrix X under
+// loss and subject to cons, starting from soln, until the weights,
+// updated in place, stop changing, and returns the final coefficients.
+// The residual scale is re-estimated from the median absolute residual
+// on every iteration, but never falls below THR
+func reweight(X *mat.Dense, energies []float64, soln *mat.Dense,
+	weights []float64, loss Loss, cons []Constraint) *mat.Dense {
+	resid := make([]float64, len(energies))
+	cutoff := loss.tuning()
+	for iter := 0; iter < robustIter; iter++ {
+		var comp mat.VecDense
+		comp.MulVec(X, soln.ColView(0))
+		for i, e := range energies {
+			resid[i] = comp.AtVec(i) - e
+		}
+		// residuals below THR are rounding noise in the energies, so
+		// the scale is not allowed to fall below it
+		s := math.Max(robustScale(resid), THR)
+		var change float64
+		for i, r := range resid {
+			w := loss.weight(r / (cutoff * s))
+			change = math.Max(change, math.Abs(w-weights[i]))
+			weights[i] = w
+		}
+		if change < robustTol {
+			return soln
+		}
+		// the initial fit has already warned about a singular X^T X
+		soln = accumulate(X, energies, weights).solve(cons, true)
+	}
+	if !Quiet {
+		fmt.Fprintf(os.Stderr, "WARNING: %v weights not converged after "+
+			"%d iterations\n", loss, robustIter)
+	}
+	return soln
+}
+
+// PrintRobustResiduals is like PrintResiduals, but also prints the
+// weight of each point from FitRobust and returns the weighted sum of
+// squared residuals
+func PrintRobustResiduals(w io.Writer, x, A *mat.Dense, energies,
+	weights []float64) (sum float64) {
+	var prod mat.Dense
+	prod.Mul(A, x)
+	computed := prod.RawMatrix().Data
+	fmt.Fprintf(w, "ROBUST FIT WITH %s LOSS\n", LOSS)
+	fmt.Fprintf(w, "%5s%20s%20s%20s%12s\n",
+		"POINT", "COMPUTED", "OBSERVED", "RESIDUAL", "WEIGHT")
+	for i, obsv := range energies {
+		comp := computed[i]
+		resi := comp - obsv
+		fmt.Fprintf(w, "%5d%20.12f%20.12f%20.8E%12.6f\n",
+			i+1, comp, obsv, resi, weights[i])
+		sum += weights[i] * resi * resi
+	}
+	fmt.Fprintf(w, "WEIGHTED SUM OF SQUARED RESIDUALS IS %17.8E\n", sum)
+	return
+}
+
+// fitResiduals fits energies with Fit, reusing check from dropCheck, and
+// prints the residuals, including the robust weights of each point if
+// LOSS is not LEASTSQ, returning the coefficients
+func fitResiduals(w io.Writer, disps *mat.Dense, energies []float64,
+	exps [][]int, check *DesignCheck) *mat.Dense {
+	if LOSS == LEASTSQ {
+		coeffs, fn := fit(disps, energies, exps, check)
+		PrintResiduals(w, coeffs, fn, energies)
+		return coeffs
+	}
+	coeffs, fn, weights := fitRobust(disps, energies, exps, check)
+	PrintRobustResiduals(w, coeffs, fn, energies, weights)
+	return coeffs
+}
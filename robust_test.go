@@ -0,0 +1,118 @@
+package anpass
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestFitRobust(t *testing.T) {
+	Quiet = true
+	defer func() {
+		Quiet = false
+		LOSS = LEASTSQ
+	}()
+	disps, energies, exps, _, _ := ReadInput("testfiles/anpass.in")
+	clean, _ := Fit(disps, energies, exps)
+	want := mat.Col(nil, 0, clean)
+	const bad = 10
+	energies[bad] += 1e-5
+	dirty, _ := Fit(disps, energies, exps)
+	lsErr := floats.Distance(mat.Col(nil, 0, dirty), want, 2)
+	tests := []struct {
+		loss    Loss
+		maxWt   float64
+		maxFrac float64
+	}{
+		{HUBER, 1e-3, 1e-3},
+		{TUKEY, 0, 1e-3},
+	}
+	for _, test := range tests {
+		LOSS = test.loss
+		soln, _, weights := FitRobust(disps, energies, exps)
+		if weights[bad] > test.maxWt {
+			t.Errorf("%v: got weight %g for the bad point, wanted <= %g\n",
+				test.loss, weights[bad], test.maxWt)
+		}
+		err := floats.Distance(mat.Col(nil, 0, soln), want, 2)
+		if err > test.maxFrac*lsErr {
+			t.Errorf("%v: got error %g, least squares %g\n",
+				test.loss, err, lsErr)
+		}
+		fit, _ := Fit(disps, energies, exps)
+		if !mat.Equal(fit, soln) {
+			t.Errorf("%v: Fit differs from FitRobust\n", test.loss)
+		}
+	}
+	LOSS = LEASTSQ
+	soln, _, weights := FitRobust(disps, energies, exps)
+	if !mat.Equal(soln, dirty) {
+		t.Error("LEASTSQ differs from Fit")
+	}
+	for _, w := range weights {
+		if w != 1 {
+			t.Fatalf("got LEASTSQ weight %g, wanted 1\n", w)
+		}
+	}
+}
+
+func TestPrintRobustResiduals(t *testing.T) {
+	Quiet = true
+	defer func() {
+		Quiet = false
+		LOSS = LEASTSQ
+	}()
+	disps, energies, exps, _, _ := ReadInput("testfiles/anpass.in")
+	energies[10] += 1e-5
+	LOSS = TUKEY
+	soln, fn, weights := FitRobust(disps, energies, exps)
+	var b strings.Builder
+	PrintRobustResiduals(&b, soln, fn, energies, weights)
+	lines := strings.Split(b.String(), "\n")
+	if lines[0] != "ROBUST FIT WITH TUKEY LOSS" {
+		t.Errorf("got header %q\n", lines[0])
+	}
+	// the 11th point is on line 12, after the two header lines
+	if fields := strings.Fields(lines[12]); fields[0] != "11" ||
+		fields[4] != "0.000000" {
+		t.Errorf("got %q for the bad point\n", lines[12])
+	}
+}
+
+func TestFitRobustQuiet(t *testing.T) {
+	defer func() {
+		LOSS = LEASTSQ
+		Constraints = nil
+	}()
+	// high powers of small displacements make X^T X nearly singular
+	const pts = 21
+	disps := mat.NewDense(pts, 1, nil)
+	energies := make([]float64, pts)
+	for i := 0; i < pts; i++ {
+		x := 0.001 * float64(i-pts/2)
+		disps.Set(i, 0, x)
+		energies[i] = x * x * (1 + x)
+	}
+	energies[3] += 1e-9
+	exps := [][]int{{0, 1, 2, 3, 4, 5, 6, 7}}
+	LOSS = HUBER
+	Constraints = []Constraint{Fix(0, 0)}
+	stderr := os.Stderr
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stderr = w
+	FitRobust(disps, energies, exps)
+	os.Stderr = stderr
+	w.Close()
+	out, _ := io.ReadAll(r)
+	// only the initial least-squares fit may warn
+	if n := strings.Count(string(out), "WARNING"); n > 1 {
+		t.Errorf("got %d warnings:\n%s", n, out)
+	}
+}
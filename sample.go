@@ -0,0 +1,278 @@
+package anpass
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// ridge is added to the diagonal of the column-scaled X^T X before
+// inverting it in Suggest, so directions the points do not determine
+// get a large but finite variance
+const ridge = 1e-10
+
+// Steps returns the step size of each coordinate in disps, taken to be
+// the smallest nonzero magnitude of its displacements
+func Steps(disps *mat.Dense) []float64 {
+	r, c := disps.Dims()
+	steps := make([]float64, c)
+	for i := 0; i < r; i++ {
+		for j, d := range disps.RawRowView(i) {
+			d = math.Abs(d)
+			if d > THR && (steps[j] == 0 || d < steps[j]) {
+				steps[j] = d
+			}
+		}
+	}
+	return steps
+}
+
+// stepIndex returns the number of steps of each coordinate of disp and
+// the total number of steps
+func stepIndex(disp, steps []float64) (idx []int, total int) {
+	idx = make([]int, len(disp))
+	for j, d := range disp {
+		if steps[j] != 0 {
+			idx[j] = int(math.Round(d / steps[j]))
+		}
+		if idx[j] < 0 {
+			total -= idx[j]
+		} else {
+			total += idx[j]
+		}
+	}
+	return
+}
+
+// Lattice returns every displacement whose coordinates are integer
+// multiples of the corresponding steps, totalling at most maxSteps steps
+func Lattice(steps []float64, maxSteps int) *mat.Dense {
+	n := len(steps)
+	var data []float64
+	idx := make([]int, n)
+	var rec func(j, left int)
+	rec = func(j, left int) {
+		if j == n {
+			for i, k := range idx {
+				data = append(data, float64(k)*steps[i])
+			}
+			return
+		}
+		for k := -left; k <= left; k++ {
+			if steps[j] == 0 && k != 0 {
+				continue
+			}
+			idx[j] = k
+			if k < 0 {
+				rec(j+1, left+k)
+			} else {
+				rec(j+1, left-k)
+			}
+		}
+		idx[j] = 0
+	}
+	rec(0, maxSteps)
+	return mat.NewDense(len(data)/n, n, data)
+}
+
+// TermIndex returns the index of the term in exps with the derivative
+// coordinates coord, in the notation of a fort.9903 file, or -1 if there
+// is no such term
+func TermIndex(exps [][]int, coord [4]int) int {
+	want := make([]int, len(exps))
+	for _, c := range coord {
+		if c > len(exps) {
+			return -1
+		} else if c > 0 {
+			want[c-1]++
+		}
+	}
+	_, coeffs := Dims(exps)
+outer:
+	for k := 0; k < coeffs; k++ {
+		for j := range exps {
+			if exps[j][k] != want[j] {
+				continue outer
+			}
+		}
+		return k
+	}
+	return -1
+}
+
+// scaledCov returns the inverse of the column-scaled X^T X for the
+// points in disps, regularized by ridge, along with the scale factor of
+// each column
+func scaledCov(s *sparse, disps *mat.Dense, coeffs int) (
+	*mat.SymDense, []float64) {
+	r, _ := disps.Dims()
+	ne := newNormal(coeffs)
+	row := make([]float64, coeffs)
+	for i := 0; i < r; i++ {
+		s.design(disps.RawRowView(i), row)
+		ne.add(row, 0)
+	}
+	scale := make([]float64, coeffs)
+	for k := range scale {
+		scale[k] = 1
+		if d := ne.XTX.At(k, k); d > 0 {
+			scale[k] = 1 / math.Sqrt(d)
+		}
+	}
+	a := mat.NewSymDense(coeffs, nil)
+	for k := 0; k < coeffs; k++ {
+		for l := k; l < coeffs; l++ {
+			a.SetSym(k, l, ne.XTX.At(k, l)*scale[k]*scale[l])
+		}
+		a.SetSym(k, k, a.At(k, k)+ridge)
+	}
+	var chol mat.Cholesky
+	if !chol.Factorize(a) {
+		panic("scaled normal equations not positive definite")
+	}
+	var cov mat.SymDense
+	chol.InverseTo(&cov)
+	return &cov, scale
+}
+
+// Suggest proposes n new points to add to the data in disps, chosen
+// greedily from the Lattice with the step sizes and largest total number
+// of steps in disps, excluding the points already present. With no
+// targets, each point maximizes the increase in det(X^T X), making the
+// design D-optimal. Otherwise each point is the one that most reduces
+// the summed variance of the coefficients with indices in targets, which
+// can be found with TermIndex. Variances are taken relative to the
+// unknown residual variance of the fit, which does not affect the
+// choice. Suggest returns the points and the score of each one when it
+// was chosen: the relative variance of the fit at the point for
+// D-optimality, or the reduction in the summed variance of the targets
+func Suggest(disps *mat.Dense, exps [][]int, n int, targets []int) (
+	*mat.Dense, []float64) {
+	_, coeffs := Dims(exps)
+	_, nvbl := disps.Dims()
+	s := compileDesign(exps)
+	steps := Steps(disps)
+	r, _ := disps.Dims()
+	have := make(map[string]bool)
+	var maxSteps int
+	for i := 0; i < r; i++ {
+		idx, tot := stepIndex(disps.RawRowView(i), steps)
+		have[fmt.Sprint(idx)] = true
+		if tot > maxSteps {
+			maxSteps = tot
+		}
+	}
+	lattice := Lattice(steps, maxSteps)
+	var cands []float64
+	lr, _ := lattice.Dims()
+	for i := 0; i < lr; i++ {
+		idx, _ := stepIndex(lattice.RawRowView(i), steps)
+		if !have[fmt.Sprint(idx)] {
+			cands = append(cands, lattice.RawRowView(i)...)
+		}
+	}
+	if len(cands) == 0 {
+		return mat.NewDense(0, nvbl, nil), nil
+	}
+	cmat := mat.NewDense(len(cands)/nvbl, nvbl, cands)
+	cov, scale := scaledCov(s, disps, coeffs)
+	picks, gains := greedy(s, cmat, cov, scale, n, targets)
+	ret := mat.NewDense(len(picks), nvbl, nil)
+	for p, c := range picks {
+		ret.SetRow(p, cmat.RawRowView(c))
+	}
+	return ret, gains
+}
+
+// greedy chooses up to n of the rows of cands one at a time as described
+// in Suggest, given the inverse cov of the column-scaled X^T X of the
+// existing points and the scale of each column. cov is overwritten.
+// greedy returns the indices of the chosen rows and their scores
+func greedy(s *sparse, cands *mat.Dense, cov *mat.SymDense, scale []float64,
+	n int, targets []int) (
+	picks []int, gains []float64) {
+	coeffs := len(scale)
+	nc, _ := cands.Dims()
+	// scaled design row of candidate c
+	f := make([]float64, coeffs)
+	row := func(c int) []float64 {
+		s.design(cands.RawRowView(c), f)
+		for k := range f {
+			f[k] *= scale[k]
+		}
+		return f
+	}
+	// d[c] is f_c^T C f_c, and t[c] holds (C f_c)_s for each target s,
+	// weighted by scale[s] to undo the column scaling
+	d := make([]float64, nc)
+	t := make([][]float64, nc)
+	cf := mat.NewVecDense(coeffs, nil)
+	for c := range d {
+		fc := mat.NewVecDense(coeffs, row(c))
+		cf.MulVec(cov, fc)
+		d[c] = mat.Dot(fc, cf)
+		t[c] = make([]float64, len(targets))
+		for i, s := range targets {
+			t[c][i] = cf.AtVec(s) * scale[s]
+		}
+	}
+	score := func(c int) float64 {
+		if len(targets) == 0 {
+			return d[c]
+		}
+		var sum float64
+		for _, v := range t[c] {
+			sum += v * v
+		}
+		return sum / (1 + d[c])
+	}
+	used := make([]bool, nc)
+	u := mat.NewVecDense(coeffs, nil)
+	for len(picks) < n {
+		best := -1
+		for c := range d {
+			if !used[c] && (best < 0 || score(c) > score(best)) {
+				best = c
+			}
+		}
+		if best < 0 {
+			break
+		}
+		used[best] = true
+		picks = append(picks, best)
+		gains = append(gains, score(best))
+		// Sherman-Morrison update of C, d, and t for the new point
+		fb := mat.NewVecDense(coeffs, row(best))
+		u.MulVec(cov, fb)
+		denom := 1 + d[best]
+		ub := make([]float64, len(targets))
+		for i, s := range targets {
+			ub[i] = u.AtVec(s) * scale[s]
+		}
+		for c := range d {
+			if used[c] {
+				continue
+			}
+			uf := mat.Dot(u, mat.NewVecDense(coeffs, row(c)))
+			d[c] -= uf * uf / denom
+			for i := range t[c] {
+				t[c][i] -= ub[i] * uf / denom
+			}
+		}
+		cov.SymRankOne(cov, -1/denom, u)
+	}
+	return
+}
+
+// WritePoints writes disps and energies as anpass data lines
+func WritePoints(w io.Writer, disps *mat.Dense, energies []float64) {
+	r, _ := disps.Dims()
+	for i := 0; i < r; i++ {
+		for _, d := range disps.RawRowView(i) {
+			fmt.Fprintf(w, "%12.8f", d)
+		}
+		fmt.Fprintf(w, "%20.12f\n", energies[i])
+	}
+}
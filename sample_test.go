@@ -0,0 +1,82 @@
+package anpass
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLattice(t *testing.T) {
+	tests := []struct {
+		steps    []float64
+		maxSteps int
+		want     int
+	}{
+		{[]float64{0.005, 0.01}, 2, 13},
+		{[]float64{0.005, 0, 0.01}, 2, 13},
+		{[]float64{0.005, 0.005, 0.005}, 1, 7},
+	}
+	for _, test := range tests {
+		if got, _ := Lattice(test.steps, test.maxSteps).Dims(); got != test.want {
+			t.Errorf("got %d, wanted %d\n", got, test.want)
+		}
+	}
+}
+
+func TestTermIndex(t *testing.T) {
+	_, _, exps, _, _ := ReadInput("testfiles/anpass.in")
+	for _, k := range []int{0, 5, 20} {
+		var coord [4]int
+		var i int
+		for j := len(exps) - 1; j >= 0; j-- {
+			for e := 0; e < exps[j][k]; e++ {
+				coord[i] = j + 1
+				i++
+			}
+		}
+		if got := TermIndex(exps, coord); got != k {
+			t.Errorf("got %d, wanted %d\n", got, k)
+		}
+	}
+	if got := TermIndex(exps, [4]int{4, 0, 0, 0}); got != -1 {
+		t.Errorf("got %d, wanted -1\n", got)
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	disps, _, exps, _, _ := ReadInput("testfiles/anpass.in")
+	steps := Steps(disps)
+	r, _ := disps.Dims()
+	have := make(map[string]bool)
+	var maxSteps int
+	for i := 0; i < r; i++ {
+		idx, tot := stepIndex(disps.RawRowView(i), steps)
+		have[fmt.Sprint(idx)] = true
+		if tot > maxSteps {
+			maxSteps = tot
+		}
+	}
+	_, coeffs := Dims(exps)
+	for _, targets := range [][]int{nil, {coeffs - 1}} {
+		points, gains := Suggest(disps, exps, 5, targets)
+		if got, _ := points.Dims(); got != 5 {
+			t.Fatalf("got %d points, wanted 5\n", got)
+		}
+		for i := range gains {
+			idx, tot := stepIndex(points.RawRowView(i), steps)
+			if have[fmt.Sprint(idx)] {
+				t.Errorf("suggested existing point %v\n", idx)
+			}
+			if tot > maxSteps {
+				t.Errorf("suggested point %v beyond %d steps\n",
+					idx, maxSteps)
+			}
+			if gains[i] <= 0 {
+				t.Errorf("got gain %g, wanted positive\n", gains[i])
+			}
+			if targets == nil && i > 0 && gains[i] > gains[i-1]*(1+1e-8) {
+				t.Errorf("gain %g increased from %g\n",
+					gains[i], gains[i-1])
+			}
+		}
+	}
+}
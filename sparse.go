@@ -0,0 +1,226 @@
+package anpass
+
+import (
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// factor is a single variable raised to a nonzero power within a term
+type factor struct {
+	v, pow int
+}
+
+// sparseTerm is a term of a polynomial with only its nonzero exponents
+type sparseTerm struct {
+	coeff   float64
+	factors []factor
+}
+
+// sparse is a polynomial compiled from a coefficient vector and
+// exponent matrix for fast evaluation. Terms with coefficients below
+// THR are dropped, and integer powers of each variable are computed
+// once per point instead of calling math.Pow for every term
+type sparse struct {
+	nvbl   int
+	maxPow int
+	terms  []sparseTerm
+}
+
+// compile returns the sparse form of the polynomial given by coeffs and
+// exps
+func compile(coeffs []float64, exps [][]int) *sparse {
+	nvbl := len(exps)
+	if nvbl > 0 && len(coeffs) != len(exps[0]) {
+		panic(fmt.Sprintf("%d coefficients for %d terms", len(coeffs),
+			len(exps[0])))
+	}
+	s := &sparse{nvbl: nvbl}
+	// count the factors in each term so they can share one allocation,
+	// walking exps by row since that is how it is stored
+	counts := make([]int, len(coeffs))
+	var nnz int
+	for j := 0; j < nvbl; j++ {
+		for k, e := range exps[j][:len(coeffs)] {
+			if e != 0 {
+				counts[k]++
+				nnz++
+				if e > s.maxPow {
+					s.maxPow = e
+				}
+			}
+		}
+	}
+	factors := make([]factor, nnz)
+	offsets := make([]int, len(coeffs)+1)
+	for k, c := range counts {
+		offsets[k+1] = offsets[k] + c
+		counts[k] = offsets[k]
+	}
+	for j := 0; j < nvbl; j++ {
+		for k, e := range exps[j][:len(coeffs)] {
+			if e != 0 {
+				factors[counts[k]] = factor{j, e}
+				counts[k]++
+			}
+		}
+	}
+	s.terms = make([]sparseTerm, 0, len(coeffs))
+	for k, c := range coeffs {
+		if math.Abs(c) < THR {
+			continue
+		}
+		s.terms = append(s.terms, sparseTerm{
+			coeff:   c,
+			factors: factors[offsets[k]:offsets[k+1]],
+		})
+	}
+	return s
+}
+
+// powers returns the table of integer powers of x, where x[j]^e is at
+// index j*(maxPow+1)+e
+func (s *sparse) powers(x []float64) []float64 {
+	stride := s.maxPow + 1
+	pows := make([]float64, s.nvbl*stride)
+	for j, xj := range x[:s.nvbl] {
+		row := pows[j*stride : (j+1)*stride]
+		row[0] = 1
+		for e := 1; e < stride; e++ {
+			row[e] = row[e-1] * xj
+		}
+	}
+	return pows
+}
+
+// pow looks up x[v]^e in the table returned by powers
+func (s *sparse) pow(pows []float64, v, e int) float64 {
+	return pows[v*(s.maxPow+1)+e]
+}
+
+// eval evaluates s at x
+func (s *sparse) eval(x []float64) float64 {
+	return s.evalPows(s.powers(x))
+}
+
+// evalPows evaluates s at the point whose powers table is pows
+func (s *sparse) evalPows(pows []float64) (sum float64) {
+	for _, t := range s.terms {
+		prod := t.coeff
+		for _, f := range t.factors {
+			prod *= s.pow(pows, f.v, f.pow)
+		}
+		sum += prod
+	}
+	return
+}
+
+// grad returns the gradient of s at x
+func (s *sparse) grad(x []float64) []float64 {
+	pows := s.powers(x)
+	grd := make([]float64, s.nvbl)
+	for _, t := range s.terms {
+		for a, fa := range t.factors {
+			coj := t.coeff * float64(fa.pow)
+			if math.Abs(coj) < THR {
+				continue
+			}
+			coj *= s.pow(pows, fa.v, fa.pow-1)
+			for b, fb := range t.factors {
+				if b != a {
+					coj *= s.pow(pows, fb.v, fb.pow)
+				}
+			}
+			grd[fa.v] += coj
+		}
+	}
+	return grd
+}
+
+// hess returns the Hessian of s at x
+func (s *sparse) hess(x []float64) *mat.SymDense {
+	pows := s.powers(x)
+	n := s.nvbl
+	hess := make([]float64, n*n)
+	for _, t := range s.terms {
+		for a, fa := range t.factors {
+			// diagonal
+			if fa.pow > 1 {
+				coj := t.coeff * float64(fa.pow*(fa.pow-1))
+				if math.Abs(coj) >= THR {
+					coj *= s.pow(pows, fa.v, fa.pow-2)
+					for c, fc := range t.factors {
+						if c != a {
+							coj *= s.pow(pows, fc.v, fc.pow)
+						}
+					}
+					hess[fa.v*n+fa.v] += coj
+				}
+			}
+			// off-diagonal, filling only the upper triangle
+			for b, fb := range t.factors[a+1:] {
+				b += a + 1
+				coj := t.coeff * float64(fa.pow*fb.pow)
+				if math.Abs(coj) < THR {
+					continue
+				}
+				coj *= s.pow(pows, fa.v, fa.pow-1) *
+					s.pow(pows, fb.v, fb.pow-1)
+				for c, fc := range t.factors {
+					if c != a && c != b {
+						coj *= s.pow(pows, fc.v, fc.pow)
+					}
+				}
+				hess[fa.v*n+fb.v] += coj
+			}
+		}
+	}
+	return mat.NewSymDense(n, hess)
+}
+
+// design fills dst with the value of each monomial of s at x, ignoring
+// the coefficients of s. s must have been compiled with every
+// coefficient nonzero
+func (s *sparse) design(x, dst []float64) {
+	pows := s.powers(x)
+	for k, t := range s.terms {
+		v := 1.0
+		for _, f := range t.factors {
+			v *= s.pow(pows, f.v, f.pow)
+		}
+		dst[k] = v
+	}
+}
+
+// compileDesign returns exps compiled for use with design
+func compileDesign(exps [][]int) *sparse {
+	_, coeffs := Dims(exps)
+	ones := make([]float64, coeffs)
+	for i := range ones {
+		ones[i] = 1
+	}
+	return compile(ones, exps)
+}
+
+// gradDesign fills dst with the derivative of each monomial of s with
+// respect to variable v at x, ignoring the coefficients of s like design
+func (s *sparse) gradDesign(x []float64, v int, dst []float64) {
+	pows := s.powers(x)
+	for k, t := range s.terms {
+		d := 0.0
+		for a, fa := range t.factors {
+			if fa.v != v {
+				continue
+			}
+			d = float64(fa.pow) * s.pow(pows, fa.v, fa.pow-1)
+			for b, fb := range t.factors {
+				if b != a {
+					d *= s.pow(pows, fb.v, fb.pow)
+				}
+			}
+			break
+		}
+		dst[k] = d
+	}
+}
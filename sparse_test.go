@@ -0,0 +1,245 @@
+package anpass
+
+import (
+	"math"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// the naive implementations of Eval, Grad, and Hess using math.Pow,
+// kept for checking and benchmarking the sparse versions
+
+func naiveEval(Xi, C []float64, exps [][]int) float64 {
+	var (
+		prod, sum float64
+		Ejk       int
+	)
+	for k := range C {
+		prod = C[k]
+		if math.Abs(prod) < THR {
+			continue
+		}
+		for j := range Xi {
+			Ejk = exps[j][k]
+			if Ejk != 0 {
+				prod *= math.Pow(Xi[j], float64(Ejk))
+			}
+		}
+		sum += prod
+	}
+	return sum
+}
+
+func naiveGrad(x []float64, coeffs *mat.Dense, exps [][]int) (grd []float64) {
+	var sum float64
+	nvbl, nunk := Dims(exps)
+	grd = make([]float64, nvbl)
+	for i := 0; i < nvbl; i++ {
+		sum = 0.0
+		for j := 0; j < nunk; j++ {
+			fij := float64(exps[i][j])
+			coj := coeffs.At(j, 0) * fij
+			if math.Abs(coj) < THR {
+				continue
+			}
+			if exps[i][j] != 1 {
+				coj *= math.Pow(x[i], fij-1)
+			}
+			for k := 0; k < nvbl; k++ {
+				ekj := exps[k][j]
+				if k != i && ekj != 0 {
+					coj *= math.Pow(x[k], float64(ekj))
+				}
+			}
+			sum += coj
+		}
+		grd[i] = sum
+	}
+	return
+}
+
+func naiveHess(x []float64, coeffs *mat.Dense, exps [][]int) *mat.SymDense {
+	nvbl, nunk := Dims(exps)
+	var (
+		il  int
+		sum float64
+	)
+	coeffSlice := coeffs.RawMatrix().Data
+	hess := make([]float64, nvbl*(nvbl-1))
+	var (
+		coj           float64
+		eij, elj, ekj int
+		fij, flj      float64
+	)
+	for i := 0; i < nvbl; i++ {
+		for l := 0; l <= i; l++ {
+			sum = 0.0
+			if i != l { // => off-diagonal
+				for j := 0; j < nunk; j++ {
+					coj = coeffSlice[j]
+					eij = exps[i][j]
+					elj = exps[l][j]
+					fij = float64(eij)
+					flj = float64(elj)
+					coj *= fij * flj
+					if math.Abs(coj) < THR {
+						continue
+					}
+					if eij != 1 {
+						coj *= math.Pow(x[i], fij-1)
+					}
+					if elj != 1 {
+						coj *= math.Pow(x[l], flj-1)
+					}
+					for k := 0; k < nvbl; k++ {
+						if k != i && k != l {
+							if ekj = exps[k][j]; ekj != 0 {
+								coj *= math.Pow(x[k], float64(ekj))
+							}
+						}
+					}
+					sum += coj
+				}
+			} else { // => diagonal
+				for j := 0; j < nunk; j++ {
+					coj = coeffSlice[j]
+					eij = exps[i][j]
+					fij = float64(eij)
+					coj *= fij * (fij - 1)
+					if math.Abs(coj) < THR {
+						continue
+					}
+					if exps[i][j] != 2 {
+						coj *= math.Pow(x[i], fij-2)
+					}
+					for k := 0; k < nvbl; k++ {
+						if k != i {
+							if ekj = exps[k][j]; ekj != 0 {
+								coj *= math.Pow(x[k], float64(ekj))
+							}
+						}
+					}
+					sum += coj
+				}
+			}
+			hess[il] = sum
+			il++
+		}
+	}
+	ret := mat.NewSymDense(nvbl, nil)
+	il = 0
+	for i := 0; i < nvbl; i++ {
+		for j := 0; j <= i; j++ {
+			ret.SetSym(i, j, hess[il])
+			il++
+		}
+	}
+	return ret
+}
+
+func sparsePoints(nvbl int) *mat.Dense {
+	const pts = 100
+	ret := mat.NewDense(pts, nvbl, nil)
+	for i := 0; i < pts; i++ {
+		for j := 0; j < nvbl; j++ {
+			ret.Set(i, j, 0.005*float64((i+j)%9-4))
+		}
+	}
+	return ret
+}
+
+func TestSparse(t *testing.T) {
+	p := Read9903("full_tests/c5h2.9903")
+	coeffs := p.coeffMat()
+	disps := sparsePoints(p.Nvbl())
+	got := p.EvalBatch(disps)
+	for i := range got {
+		x := disps.RawRowView(i)
+		want := naiveEval(x, p.Coeffs, p.Exps)
+		if !nearby(got[i], want, 1e-12*math.Max(1, math.Abs(want))) {
+			t.Errorf("Eval: got %v, wanted %v\n", got[i], want)
+		}
+		if !eql(Grad(x, coeffs, p.Exps),
+			naiveGrad(x, coeffs, p.Exps), 1e-10) {
+			t.Error("Grad mismatch")
+		}
+		h, wh := Hess(x, coeffs, p.Exps), naiveHess(x, coeffs, p.Exps)
+		if !mat.EqualApprox(h, wh, 1e-10) {
+			t.Error("Hess mismatch")
+		}
+	}
+}
+
+func TestGradColumns(t *testing.T) {
+	Quiet = true
+	defer func() {
+		Quiet = false
+	}()
+	disps, energies, exps, _, _ := ReadInput("testfiles/anpass.in")
+	Y := mat.NewDense(len(energies), 2, nil)
+	Y.SetCol(0, energies)
+	for i := range energies {
+		Y.Set(i, 1, dipz(disps.RawRowView(i)))
+	}
+	multi, _ := FitMulti(disps, Y, exps)
+	_, terms := Dims(exps)
+	// column 0 of a multi-column matrix and of a view of it
+	single := mat.NewDense(terms, 1, mat.Col(nil, 0, multi))
+	view := multi.Slice(0, terms, 0, 1).(*mat.Dense)
+	x := []float64{0.01, 0, 0}
+	want := naiveGrad(x, single, exps)
+	wantH := naiveHess(x, single, exps)
+	for _, coeffs := range []*mat.Dense{multi, view} {
+		if got := Grad(x, coeffs, exps); !eql(got, want, 1e-12) {
+			t.Errorf("Grad: got %v, wanted %v\n", got, want)
+		}
+		if got := Hess(x, coeffs, exps); !mat.EqualApprox(got, wantH,
+			1e-12) {
+			t.Errorf("Hess: got %v, wanted %v\n", got, wantH)
+		}
+	}
+}
+
+func BenchmarkEvalBatch(b *testing.B) {
+	p := Read9903("full_tests/c5h2.9903")
+	disps := sparsePoints(p.Nvbl())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.EvalBatch(disps)
+	}
+}
+
+func BenchmarkEvalBatchNaive(b *testing.B) {
+	p := Read9903("full_tests/c5h2.9903")
+	disps := sparsePoints(p.Nvbl())
+	r, _ := disps.Dims()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < r; j++ {
+			naiveEval(disps.RawRowView(j), p.Coeffs, p.Exps)
+		}
+	}
+}
+
+func BenchmarkGradHess(b *testing.B) {
+	p := Read9903("full_tests/c5h2.9903")
+	x := sparsePoints(p.Nvbl()).RawRowView(1)
+	s := compile(p.Coeffs, p.Exps)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.grad(x)
+		s.hess(x)
+	}
+}
+
+func BenchmarkGradHessNaive(b *testing.B) {
+	p := Read9903("full_tests/c5h2.9903")
+	x := sparsePoints(p.Nvbl()).RawRowView(1)
+	coeffs := p.coeffMat()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		naiveGrad(x, coeffs, p.Exps)
+		naiveHess(x, coeffs, p.Exps)
+	}
+}
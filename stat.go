@@ -1,5 +1,10 @@
 package anpass
 
+import (
+	"fmt"
+	"math"
+)
+
 // Stat is a type of stationary point
 type Stat int
 
@@ -7,6 +12,7 @@ const (
 	MAX Stat = iota
 	MIN
 	STAT
+	SADDLE
 )
 
 func (s Stat) String() string {
@@ -14,6 +20,46 @@ func (s Stat) String() string {
 		"M A X I M U M",
 		"M I N I M U M",
 		"S T A T I O N A R Y  P O I N T",
+		"S A D D L E  P O I N T",
 	}[s]
 }
 
+// Character describes a stationary point by its Stat, its Hessian Index
+// (the number of negative eigenvalues), and the number of eigenvalues
+// Zero within the threshold of zero
+type Character struct {
+	Kind  Stat
+	Index int
+	Zero  int
+}
+
+// Classify characterizes a stationary point from the eigenvalues of its
+// Hessian, counting eigenvalues with magnitude below thr as zero
+func Classify(evals []float64, thr float64) (c Character) {
+	for _, v := range evals {
+		switch {
+		case math.Abs(v) < thr:
+			c.Zero++
+		case v < 0:
+			c.Index++
+		}
+	}
+	switch nonzero := len(evals) - c.Zero; {
+	case nonzero == 0:
+		c.Kind = STAT
+	case c.Index == 0:
+		c.Kind = MIN
+	case c.Index == nonzero:
+		c.Kind = MAX
+	default:
+		c.Kind = SADDLE
+	}
+	return
+}
+
+func (c Character) String() string {
+	if c.Kind == SADDLE {
+		return fmt.Sprintf("SADDLE POINT OF ORDER %d", c.Index)
+	}
+	return c.Kind.String()
+}
@@ -0,0 +1,83 @@
+package anpass
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// StreamFit fits the data points in the anpass input file filename like
+// Fit, but reads them one at a time into the normal equations instead
+// of building the whole design matrix, so memory use depends only on
+// the number of coefficients. The file is read once for its FUNCTION and
+// STATIONARY POINT sections, which follow the data, and once more for
+// the points. Each point is biased by the STATIONARY POINT of the input
+// plus shift, which may be nil. The returned ssr is the sum of squared
+// residuals, found by a third read. Since no reference energy can be
+// subtracted before the points are read, StreamFit returns an error for
+// absolute energies unless REFERENCE is NOREF, and for any REFERENCE
+// that would subtract one from relative energies
+func StreamFit(filename string, shift []float64) (soln *mat.Dense,
+	in *Input, ssr float64, err error) {
+	if REFERENCE == ORIGIN || REFERENCE == MINIMUM {
+		return nil, nil, 0, fmt.Errorf("streamed fits cannot subtract " +
+			"a reference energy")
+	}
+	in = parseFile(filename, nil)
+	biases := make([]float64, len(in.Biases))
+	copy(biases, in.Biases)
+	for i := range shift {
+		biases[i] += shift[i]
+	}
+	in.Biases = biases
+	_, coeffs := Dims(in.Exps)
+	s := compileDesign(in.Exps)
+	ne := newNormal(coeffs)
+	row := make([]float64, coeffs)
+	x := make([]float64, in.Nvbl)
+	bias := func(p Point) float64 {
+		for j := range x {
+			x[j] = p.Disp[j] - biases[j]
+		}
+		return p.Energy - biases[in.Nvbl]
+	}
+	absolute := false
+	parseFile(filename, func(p Point) {
+		absolute = absolute || math.Abs(p.Energy) > absThr
+		e := bias(p)
+		s.design(x, row)
+		ne.add(row, e)
+	})
+	if absolute && REFERENCE != NOREF {
+		return nil, nil, 0, fmt.Errorf("streamed fits need relative " +
+			"energies, or no reference energy")
+	}
+	soln = ne.solve(Constraints, Quiet)
+	poly := compile(soln.RawMatrix().Data, in.Exps)
+	parseFile(filename, func(p Point) {
+		e := bias(p)
+		r := poly.eval(x) - e
+		ssr += r * r
+	})
+	return soln, in, ssr, nil
+}
+
+// RunStream is like Run, but fits the input file filename with
+// StreamFit, biased by shift relative to the input's own STATIONARY
+// POINT. The residuals are summarized instead of printed point by point.
+// The error is that of StreamFit
+func RunStream(w io.Writer, dir, filename string, shift []float64) (
+	longLine []float64, fcs []FC, stationary bool, err error) {
+	coeffs, in, ssr, err := StreamFit(filename, shift)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	PrintBias(w, in.Biases)
+	fmt.Fprintf(w, "WEIGHTED SUM OF SQUARED RESIDUALS IS %17.8E\n", ssr)
+	fcs = Write9903(filepath.Join(dir, "fort.9903"), coeffs, in.Exps)
+	longLine = locate(w, coeffs, in.Exps)
+	return longLine, fcs, atOrigin(longLine), nil
+}
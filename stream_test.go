@@ -0,0 +1,76 @@
+package anpass
+
+import (
+	"io"
+	"testing"
+)
+
+func TestStreamFit(t *testing.T) {
+	disps, energies, exps, _, _ := ReadInput("testfiles/anpass.in")
+	want, fn := Fit(disps, energies, exps)
+	got, in, ssr, err := StreamFit("testfiles/anpass.in", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deepError(t, in.Exps, exps)
+	if !eql(got.RawMatrix().Data, want.RawMatrix().Data, 1e-9) {
+		t.Errorf("got %v, wanted %v\n", got.RawMatrix().Data,
+			want.RawMatrix().Data)
+	}
+	wssr := PrintResiduals(io.Discard, want, fn, energies)
+	if !nearby(ssr, wssr, 1e-20) {
+		t.Errorf("got %v, wanted %v\n", ssr, wssr)
+	}
+}
+
+func TestRunStream(t *testing.T) {
+	Quiet = true
+	defer func() {
+		Quiet = false
+	}()
+	dir := t.TempDir()
+	longLine, _, stat, err := RunStream(io.Discard, dir,
+		"full_tests/h2o.in", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat {
+		t.Error("first pass should not be at the stationary point")
+	}
+	lline := []float64{
+		-0.000045311426, -0.000027076533,
+		0.000000000000, -0.000000002131,
+	}
+	if !eql(longLine, lline, 1e-11) {
+		t.Errorf("got %v, wanted %v\n", longLine, lline)
+	}
+	_, got, stat, err := RunStream(io.Discard, dir, "full_tests/h2o.in",
+		longLine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stat {
+		t.Error("second pass should be at the stationary point")
+	}
+	if !compFC(got, load9903("full_tests/h2o.9903"), 1e-7) {
+		t.Error("force constants mismatch")
+	}
+}
+
+func TestStreamFitReference(t *testing.T) {
+	defer func() {
+		REFERENCE = AUTO
+	}()
+	infile := absoluteInput(t, t.TempDir(), "-76.123456789012")
+	if _, _, _, err := StreamFit(infile, nil); err == nil {
+		t.Error("streamed absolute energies")
+	}
+	REFERENCE = NOREF
+	if _, _, _, err := StreamFit(infile, nil); err != nil {
+		t.Errorf("got %v with no reference\n", err)
+	}
+	REFERENCE = ORIGIN
+	if _, _, _, err := StreamFit("testfiles/anpass.in", nil); err == nil {
+		t.Error("streamed with an ORIGIN reference")
+	}
+}
@@ -0,0 +1,163 @@
+package anpass
+
+import (
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+)
+
+// rankTol is the size, relative to the largest row of the scaled design
+// matrix, below which the part of a row not spanned by the rows already
+// chosen by Select is considered zero
+const rankTol = 1e-8
+
+// scaleColumns returns the factor scaling each column of the design
+// matrix for the points in disps to unit norm
+func scaleColumns(s *sparse, disps *mat.Dense, coeffs int) []float64 {
+	r, _ := disps.Dims()
+	row := make([]float64, coeffs)
+	scale := make([]float64, coeffs)
+	for i := 0; i < r; i++ {
+		s.design(disps.RawRowView(i), row)
+		for k, v := range row {
+			scale[k] += v * v
+		}
+	}
+	for k, v := range scale {
+		if v > 0 {
+			scale[k] = 1 / math.Sqrt(v)
+		} else {
+			scale[k] = 1
+		}
+	}
+	return scale
+}
+
+// Select chooses a subset of the points in disps that determines every
+// coefficient of the polynomial given by exps, before any energies are
+// known. The first points are chosen by QR factorization of X^T with
+// column pivoting, giving the smallest subset of full rank, and points
+// are then added greedily as in Suggest until the condition number of
+// the reduced design matrix is at most maxCond. A maxCond of 0 skips
+// the second step. The condition number is that of the design matrix
+// with each column scaled to unit norm over all of disps, so the
+// differing magnitudes of the monomials do not dominate it. Select
+// returns the indices of the chosen points in disps, in the order they
+// were chosen, and the condition number of the reduced design. The
+// error is non-nil if disps cannot determine every coefficient
+func Select(disps *mat.Dense, exps [][]int, maxCond float64) (
+	idx []int, cond float64, err error) {
+	pts, nvbl := disps.Dims()
+	_, coeffs := Dims(exps)
+	s := compileDesign(exps)
+	scale := scaleColumns(s, disps, coeffs)
+	// pivoted modified Gram-Schmidt on the rows of the scaled design
+	// matrix
+	R := mat.NewDense(pts, coeffs, nil)
+	norms := make([]float64, pts)
+	var maxNorm float64
+	for i := 0; i < pts; i++ {
+		row := R.RawRowView(i)
+		s.design(disps.RawRowView(i), row)
+		floats.Mul(row, scale)
+		norms[i] = floats.Norm(row, 2)
+		maxNorm = math.Max(maxNorm, norms[i])
+	}
+	used := make([]bool, pts)
+	for len(idx) < coeffs {
+		best := -1
+		for i, n := range norms {
+			if !used[i] && (best < 0 || n > norms[best]) {
+				best = i
+			}
+		}
+		if best < 0 || norms[best] < rankTol*maxNorm {
+			break
+		}
+		used[best] = true
+		idx = append(idx, best)
+		q := R.RawRowView(best)
+		floats.Scale(1/floats.Norm(q, 2), q)
+		for i := range norms {
+			if used[i] {
+				continue
+			}
+			row := R.RawRowView(i)
+			floats.AddScaled(row, -floats.Dot(q, row), q)
+			norms[i] = floats.Norm(row, 2)
+		}
+	}
+	if len(idx) < coeffs {
+		err = fmt.Errorf("anpass: points determine only %d of %d coefficients",
+			len(idx), coeffs)
+	}
+	cond = selectCond(s, disps, idx, scale)
+	if err != nil || maxCond <= 0 || cond <= maxCond || len(idx) == pts {
+		return
+	}
+	// order the remaining points greedily, then take the shortest
+	// prefix of them that brings the condition number below maxCond.
+	// Adding points does not strictly have to improve the condition
+	// number, but it does in practice, so the prefix is found by
+	// bisection
+	ne := newNormal(coeffs)
+	row := make([]float64, coeffs)
+	for _, i := range idx {
+		s.design(disps.RawRowView(i), row)
+		floats.Mul(row, scale)
+		ne.add(row, 0)
+	}
+	var chol mat.Cholesky
+	if !chol.Factorize(ne.XTX) {
+		panic("selected points not of full rank")
+	}
+	var cov mat.SymDense
+	chol.InverseTo(&cov)
+	var rest []int
+	for i := 0; i < pts; i++ {
+		if !used[i] {
+			rest = append(rest, i)
+		}
+	}
+	cands := mat.NewDense(len(rest), nvbl, nil)
+	for c, i := range rest {
+		cands.SetRow(c, disps.RawRowView(i))
+	}
+	picks, _ := greedy(s, cands, &cov, scale, len(rest), nil)
+	base := len(idx)
+	for _, c := range picks {
+		idx = append(idx, rest[c])
+	}
+	lo, hi := 0, len(picks)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if selectCond(s, disps, idx[:base+mid], scale) <= maxCond {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	idx = idx[:base+lo]
+	cond = selectCond(s, disps, idx, scale)
+	return
+}
+
+// selectCond returns the 2-norm condition number of the design matrix
+// for the points in disps with indices idx, with its columns multiplied
+// by scale
+func selectCond(s *sparse, disps *mat.Dense, idx []int,
+	scale []float64) float64 {
+	if len(idx) == 0 {
+		return math.Inf(1)
+	}
+	coeffs := len(scale)
+	X := mat.NewDense(len(idx), coeffs, nil)
+	for p, i := range idx {
+		row := X.RawRowView(p)
+		s.design(disps.RawRowView(i), row)
+		floats.Mul(row, scale)
+	}
+	return mat.Cond(X, 2)
+}
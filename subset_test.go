@@ -0,0 +1,48 @@
+package anpass
+
+import (
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestSelect(t *testing.T) {
+	disps, _, exps, _, _ := ReadInput("testfiles/anpass.in")
+	_, coeffs := Dims(exps)
+	tests := []struct {
+		maxCond float64
+		want    int
+	}{
+		{0, coeffs},
+		{20, 63},
+	}
+	for _, test := range tests {
+		idx, cond, err := Select(disps, exps, test.maxCond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(idx) != test.want {
+			t.Errorf("got %d points, wanted %d\n", len(idx), test.want)
+		}
+		if test.maxCond > 0 && cond > test.maxCond {
+			t.Errorf("got cond %g, wanted at most %g\n", cond, test.maxCond)
+		}
+		seen := make(map[int]bool)
+		for _, i := range idx {
+			if seen[i] {
+				t.Errorf("point %d selected twice\n", i)
+			}
+			seen[i] = true
+		}
+	}
+	// points along the first coordinate alone cannot determine the
+	// other terms
+	_, nvbl := disps.Dims()
+	line := mat.NewDense(9, nvbl, nil)
+	for i := 0; i < 9; i++ {
+		line.Set(i, 0, 0.005*float64(i-4))
+	}
+	if _, _, err := Select(line, exps, 0); err == nil {
+		t.Error("expected rank deficiency error")
+	}
+}
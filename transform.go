@@ -0,0 +1,140 @@
+package anpass
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"strings"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// ReadMatrix reads a matrix from filename, with one row per non-empty
+// line and whitespace-separated columns
+func ReadMatrix(filename string) *mat.Dense {
+	f, err := os.Open(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	var (
+		data       []float64
+		rows, cols int
+	)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if cols == 0 {
+			cols = len(fields)
+		} else if len(fields) != cols {
+			panic("ragged matrix in " + filename)
+		}
+		data = append(data, toFloat(fields...)...)
+		rows++
+	}
+	return mat.NewDense(rows, cols, data)
+}
+
+// Degree returns the highest total degree of any term in p
+func (p *Polynomial) Degree() (deg int) {
+	for k := range p.Coeffs {
+		var d int
+		for j := range p.Exps {
+			d += p.Exps[j][k]
+		}
+		if d > deg {
+			deg = d
+		}
+	}
+	return
+}
+
+// Transform returns p expressed in the coordinates q = Lx, where x are
+// the coordinates of p. Each derivative tensor of p at the origin is
+// transformed through the inverse of L, so the result contains every
+// term up to the degree of p whose coefficient is at least THR in
+// magnitude, ordered by degree
+func (p *Polynomial) Transform(L *mat.Dense) (*Polynomial, error) {
+	n := p.Nvbl()
+	if r, c := L.Dims(); r != n || c != n {
+		panic("transformation matrix does not match polynomial")
+	}
+	var M mat.Dense
+	if err := M.Inverse(L); err != nil {
+		return nil, err
+	}
+	ret := &Polynomial{Exps: make([][]int, n)}
+	origin := make([]float64, n)
+	for order := 0; order <= p.Degree(); order++ {
+		t := p.Tensor(origin, order)
+		for mode := 0; mode < order; mode++ {
+			t = modeProduct(t, order, mode, n, &M)
+		}
+		for _, exps := range monomials(n, order) {
+			flat, ifact := 0, 1
+			for j, e := range exps {
+				for i := 0; i < e; i++ {
+					flat = flat*n + j
+				}
+				ifact *= factorial(e)
+			}
+			c := t[flat] / float64(ifact)
+			if math.Abs(c) < THR {
+				continue
+			}
+			ret.Coeffs = append(ret.Coeffs, c)
+			for j, e := range exps {
+				ret.Exps[j] = append(ret.Exps[j], e)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// modeProduct contracts index mode of the flattened symmetric-shaped
+// tensor t of the given order and dimension n with the first index of
+// M, returning t' with t'[..i..] = sum_a t[..a..] M[a][i]
+func modeProduct(t []float64, order, mode, n int, M mat.Matrix) []float64 {
+	right := 1
+	for i := mode + 1; i < order; i++ {
+		right *= n
+	}
+	left := len(t) / (n * right)
+	ret := make([]float64, len(t))
+	for l := 0; l < left; l++ {
+		for i := 0; i < n; i++ {
+			for a := 0; a < n; a++ {
+				m := M.At(a, i)
+				if m == 0 {
+					continue
+				}
+				src := t[(l*n+a)*right : (l*n+a+1)*right]
+				dst := ret[(l*n+i)*right : (l*n+i+1)*right]
+				for r, v := range src {
+					dst[r] += v * m
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// monomials returns the exponents of every monomial of total degree deg
+// in n variables, with higher powers of earlier variables first
+func monomials(n, deg int) (ret [][]int) {
+	if n == 0 {
+		if deg == 0 {
+			ret = append(ret, []int{})
+		}
+		return
+	}
+	for e := deg; e >= 0; e-- {
+		for _, rest := range monomials(n-1, deg-e) {
+			ret = append(ret, append([]int{e}, rest...))
+		}
+	}
+	return
+}
@@ -0,0 +1,62 @@
+package anpass
+
+import (
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestTransform(t *testing.T) {
+	p := Read9903("full_tests/h2o.9903")
+	// symmetric and antisymmetric combinations of the first two
+	// coordinates
+	L := mat.NewDense(3, 3, []float64{
+		0.7071067811865476, 0.7071067811865476, 0,
+		0.7071067811865476, -0.7071067811865476, 0,
+		0, 0, 2,
+	})
+	q, err := p.Transform(L)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range [][]float64{
+		{0.01, 0.005, -0.01},
+		{-0.02, 0.01, 0.005},
+		{0.005, -0.015, 0.02},
+	} {
+		var y mat.VecDense
+		y.MulVec(L, mat.NewVecDense(3, x))
+		want := p.Eval(x)
+		if got := q.Eval(y.RawVector().Data); !nearby(got, want, 1e-12) {
+			t.Errorf("got %v, wanted %v\n", got, want)
+		}
+	}
+	var inv mat.Dense
+	inv.Inverse(L)
+	back, err := q.Transform(&inv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[[4]int]float64)
+	for _, fc := range back.ForceConstants() {
+		got[fc.Coord] = fc.Val
+	}
+	for _, fc := range p.ForceConstants() {
+		if !nearby(got[fc.Coord], fc.Val, 1e-9) {
+			t.Errorf("round trip %v: got %v, wanted %v\n",
+				fc.Coord, got[fc.Coord], fc.Val)
+		}
+	}
+	if _, err := p.Transform(mat.NewDense(3, 3, nil)); err == nil {
+		t.Error("expected error for singular matrix")
+	}
+}
+
+func TestMonomials(t *testing.T) {
+	got := monomials(3, 2)
+	want := [][]int{
+		{2, 0, 0}, {1, 1, 0}, {1, 0, 1},
+		{0, 2, 0}, {0, 1, 1}, {0, 0, 2},
+	}
+	deepError(t, got, want)
+}
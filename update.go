@@ -0,0 +1,160 @@
+package anpass
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// ErrNotPosDef is returned by Updater when X^T X is not positive
+// definite, meaning the points no longer determine every coefficient
+var ErrNotPosDef = errors.New("anpass: normal equations not positive definite")
+
+// Updater is a least-squares fit that can be updated as points are added
+// or removed. It keeps the Cholesky factorization of X^T X and applies a
+// rank-one update or downdate for each point instead of refitting from
+// scratch, so it requires the fit to be of full rank throughout
+type Updater struct {
+	Exps     [][]int
+	Disps    [][]float64
+	Energies []float64
+
+	design *sparse
+	row    []float64
+	chol   mat.Cholesky
+	xty    *mat.VecDense
+	coeffs *mat.VecDense
+}
+
+// FitStats summarizes the quality of a fit
+type FitStats struct {
+	Points int
+	SSR    float64
+	RMS    float64
+	// Cond is the condition number of X^T X
+	Cond float64
+}
+
+// NewUpdater returns an Updater for fitting disps and energies with the
+// polynomial given by exps
+func NewUpdater(disps *mat.Dense, energies []float64, exps [][]int) (
+	*Updater, error) {
+	_, coeffs := Dims(exps)
+	u := &Updater{
+		Exps:   exps,
+		design: compileDesign(exps),
+		row:    make([]float64, coeffs),
+		coeffs: mat.NewVecDense(coeffs, nil),
+	}
+	ne := newNormal(coeffs)
+	for i, e := range energies {
+		d := append([]float64(nil), disps.RawRowView(i)...)
+		u.Disps = append(u.Disps, d)
+		u.Energies = append(u.Energies, e)
+		u.design.design(d, u.row)
+		ne.add(u.row, e)
+	}
+	if !u.chol.Factorize(ne.XTX) {
+		return nil, ErrNotPosDef
+	}
+	u.xty = ne.XTy
+	return u, u.solve()
+}
+
+// solve updates the coefficients from the factorization, only warning
+// about ill conditioning like Fit
+func (u *Updater) solve() error {
+	err := u.chol.SolveVecTo(u.coeffs, u.xty)
+	if _, ok := err.(mat.Condition); ok {
+		if !Quiet {
+			fmt.Fprintf(os.Stderr, "WARNING: %v\n", err)
+		}
+		return nil
+	}
+	return err
+}
+
+// update applies the rank-one change alpha * row row^T for the point
+// at disp with energy to the factorization and X^T y
+func (u *Updater) update(disp []float64, energy, alpha float64) error {
+	u.design.design(disp, u.row)
+	v := mat.NewVecDense(len(u.row), u.row)
+	var chol mat.Cholesky
+	if !chol.SymRankOne(&u.chol, alpha, v) {
+		return ErrNotPosDef
+	}
+	u.chol.Clone(&chol)
+	u.xty.AddScaledVec(u.xty, alpha*energy, v)
+	return u.solve()
+}
+
+// Add adds the point at disp with energy to the fit
+func (u *Updater) Add(disp []float64, energy float64) error {
+	d := append([]float64(nil), disp...)
+	if err := u.update(d, energy, 1); err != nil {
+		return err
+	}
+	u.Disps = append(u.Disps, d)
+	u.Energies = append(u.Energies, energy)
+	return nil
+}
+
+// Remove removes point i from the fit. The fit is left unchanged if
+// removing the point would make it rank deficient
+func (u *Updater) Remove(i int) error {
+	if err := u.update(u.Disps[i], u.Energies[i], -1); err != nil {
+		return err
+	}
+	u.Disps = append(u.Disps[:i], u.Disps[i+1:]...)
+	u.Energies = append(u.Energies[:i], u.Energies[i+1:]...)
+	return nil
+}
+
+// Coeffs returns the current coefficients in the form returned by Fit
+func (u *Updater) Coeffs() *mat.Dense {
+	c := u.coeffs.RawVector().Data
+	return mat.NewDense(len(c), 1, append([]float64(nil), c...))
+}
+
+// Computed returns the value of the fit at each point
+func (u *Updater) Computed() []float64 {
+	poly := compile(u.coeffs.RawVector().Data, u.Exps)
+	ret := make([]float64, len(u.Disps))
+	for i, d := range u.Disps {
+		ret[i] = poly.eval(d)
+	}
+	return ret
+}
+
+// Residuals returns the computed minus the observed energy of each
+// point
+func (u *Updater) Residuals() []float64 {
+	ret := u.Computed()
+	for i, e := range u.Energies {
+		ret[i] -= e
+	}
+	return ret
+}
+
+// Stats returns the statistics of the current fit
+func (u *Updater) Stats() (s FitStats) {
+	s.Points = len(u.Energies)
+	for _, r := range u.Residuals() {
+		s.SSR += r * r
+	}
+	if s.Points > 0 {
+		s.RMS = math.Sqrt(s.SSR / float64(s.Points))
+	}
+	s.Cond = u.chol.Cond()
+	return
+}
+
+// PrintResiduals prints the residuals of the current fit like the
+// function of the same name and returns the sum of squared residuals
+func (u *Updater) PrintResiduals(w io.Writer) float64 {
+	return printResiduals(w, u.Computed(), u.Energies)
+}
@@ -0,0 +1,47 @@
+package anpass
+
+import (
+	"io"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestUpdater(t *testing.T) {
+	disps, energies, exps, _, _ := ReadInput("testfiles/anpass.in")
+	r, c := disps.Dims()
+	// start without the last point, then add it back
+	u, err := NewUpdater(disps.Slice(0, r-1, 0, c).(*mat.Dense),
+		energies[:r-1], exps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Add(disps.RawRowView(r-1), energies[r-1]); err != nil {
+		t.Fatal(err)
+	}
+	want, fn := Fit(disps, energies, exps)
+	if !eql(u.Coeffs().RawMatrix().Data, want.RawMatrix().Data, 1e-9) {
+		t.Errorf("Add: got %v, wanted %v\n", u.Coeffs().RawMatrix().Data,
+			want.RawMatrix().Data)
+	}
+	wssr := PrintResiduals(io.Discard, want, fn, energies)
+	stats := u.Stats()
+	if stats.Points != r || !nearby(stats.SSR, wssr, 1e-20) {
+		t.Errorf("got %+v, wanted SSR %v\n", stats, wssr)
+	}
+	if got := u.PrintResiduals(io.Discard); got != stats.SSR {
+		t.Errorf("got %v, wanted %v\n", got, stats.SSR)
+	}
+	// removing the first point should match fitting without it
+	if err := u.Remove(0); err != nil {
+		t.Fatal(err)
+	}
+	want, _ = Fit(disps.Slice(1, r, 0, c).(*mat.Dense), energies[1:], exps)
+	if !eql(u.Coeffs().RawMatrix().Data, want.RawMatrix().Data, 1e-9) {
+		t.Errorf("Remove: got %v, wanted %v\n",
+			u.Coeffs().RawMatrix().Data, want.RawMatrix().Data)
+	}
+	if len(u.Residuals()) != r-1 {
+		t.Errorf("got %d residuals, wanted %d\n", len(u.Residuals()), r-1)
+	}
+}